@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/templates"
+	"github.com/go-chi/chi/v5"
+)
+
+// adminOnly lets through only users which are Gitea site administrators.
+// Must be used after authenticatedGiteaClient.
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
+		user, _, err := client.GetMyUserInfo()
+		if err != nil {
+			slog.Error("failed to get current user", "err", err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		if !user.IsAdmin {
+			slog.Warn("non-admin user tried to access admin endpoint", "user", user.UserName, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isSiteAdmin reports whether the user of the request is a Gitea site administrator,
+// the request must have passed authenticatedGiteaClient
+func isSiteAdmin(r *http.Request) bool {
+	client, ok := r.Context().Value(clientCtxKey{}).(*gitea.Client)
+	if !ok {
+		return false
+	}
+	user, _, err := client.GetMyUserInfo()
+	if err != nil {
+		slog.Warn("failed to get current user", "err", err)
+		return false
+	}
+	return user.IsAdmin
+}
+
+// sameOriginOnly rejects state-changing requests sent by browsers from other origins: pages of
+// owner subdomains are same-site with the pages server, so SameSite cookies do not stop them from
+// submitting forms. Sec-Fetch-Site is checked first, then Origin, requests of clients sending
+// neither header, like curl, are not sent by a page and are let through.
+func sameOriginOnly(pages PagesInfo) func(http.Handler) http.Handler {
+	return originOnly(func(*http.Request) string { return pages.URL })
+}
+
+// siteOriginOnly is sameOriginOnly for custom domains, owner subdomains and site origins,
+// which are origins of their own
+func siteOriginOnly(pages PagesInfo) func(http.Handler) http.Handler {
+	scheme := "http"
+	if pages.Secure() {
+		scheme = "https"
+	}
+	return originOnly(func(r *http.Request) string { return scheme + "://" + r.Host })
+}
+
+// originOnly rejects state-changing requests from origins other than the one returned by origin
+func originOnly(origin func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !sameOrigin(origin(r), r) {
+				slog.Warn("cross-origin request rejected", "path", r.URL.Path,
+					"origin", r.Header.Get("Origin"), "fetchSite", r.Header.Get("Sec-Fetch-Site"))
+				http.Error(w, "cross-origin request", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func sameOrigin(expected string, r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	p, err := url.Parse(expected)
+	return err == nil && o.Scheme == p.Scheme && strings.EqualFold(o.Host, p.Host)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write json", "err", err)
+	}
+}
+
+// reconcileWebhooksHandler triggers webhook reconciliation, ?dry_run=true only reports the changes
+func reconcileWebhooksHandler(wr *webhookReconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		actions, err := wr.Reconcile(r.Context(), dryRun)
+		if err != nil {
+			slog.Error("failed to reconcile webhooks", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, actions)
+	}
+}
+
+// wantsHTML reports whether the request came from a browser rather than an API client
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// queuesHandler lists jobs of all queues as JSON or, for browsers, as an HTML page
+func queuesHandler(gi GiteaPagesInfo, q *database.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := q.Status()
+		if err != nil {
+			slog.Error("failed to get queue status", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !wantsHTML(r) {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		if err := templates.Queues.Execute(w, struct {
+			Info   GiteaPagesInfo
+			Queues []database.QueueStatus
+		}{
+			Info:   gi,
+			Queues: status,
+		}); err != nil {
+			slog.Error("failed to execute queues template", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// queueJobHandler applies action to the job identified by {queue}/{id}
+func queueJobHandler(action func(key string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "queue") + "/" + chi.URLParam(r, "id")
+		if err := action(key); err != nil {
+			slog.Error("failed to update job", "job", key, "path", r.URL.Path, "err", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, database.ErrJobNotFound) || errors.Is(err, database.ErrUnknownQueue) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if wantsHTML(r) {
+			http.Redirect(w, r, "/_admin/queues", http.StatusSeeOther)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"job": key, "status": "ok"})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOriginOnly(t *testing.T) {
+	pages := PagesInfo{URL: "https://pages.example.com"}
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		status int
+	}{
+		{name: "get from other site", method: http.MethodGet, header: http.Header{"Sec-Fetch-Site": {"cross-site"}}, status: http.StatusOK},
+		{name: "same origin", method: http.MethodPost, header: http.Header{"Sec-Fetch-Site": {"same-origin"}}, status: http.StatusOK},
+		{name: "typed by user", method: http.MethodPost, header: http.Header{"Sec-Fetch-Site": {"none"}}, status: http.StatusOK},
+		{name: "owner subdomain", method: http.MethodPost, header: http.Header{"Sec-Fetch-Site": {"same-site"}}, status: http.StatusForbidden},
+		{name: "other site", method: http.MethodDelete, header: http.Header{"Sec-Fetch-Site": {"cross-site"}}, status: http.StatusForbidden},
+		{name: "matching origin", method: http.MethodPost, header: http.Header{"Origin": {"https://pages.example.com"}}, status: http.StatusOK},
+		{name: "subdomain origin", method: http.MethodPost, header: http.Header{"Origin": {"https://owner.pages.example.com"}}, status: http.StatusForbidden},
+		{name: "other scheme", method: http.MethodPut, header: http.Header{"Origin": {"http://pages.example.com"}}, status: http.StatusForbidden},
+		{name: "opaque origin", method: http.MethodPost, header: http.Header{"Origin": {"null"}}, status: http.StatusForbidden},
+		{name: "no browser headers", method: http.MethodPost, status: http.StatusOK},
+	}
+	handler := sameOriginOnly(pages)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/_admin/gc", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestSiteOriginOnly(t *testing.T) {
+	pages := PagesInfo{URL: "https://pages.example.com"}
+	tests := []struct {
+		name   string
+		host   string
+		header http.Header
+		status int
+	}{
+		{name: "same origin", host: "docs.example.org", header: http.Header{"Sec-Fetch-Site": {"same-origin"}}, status: http.StatusOK},
+		{name: "matching origin", host: "docs.example.org", header: http.Header{"Origin": {"https://docs.example.org"}}, status: http.StatusOK},
+		{name: "pages server origin", host: "docs.example.org", header: http.Header{"Origin": {"https://pages.example.com"}}, status: http.StatusForbidden},
+		{name: "other owner subdomain", host: "owner.pages.example.com", header: http.Header{"Origin": {"https://evil.pages.example.com"}}, status: http.StatusForbidden},
+		{name: "other site", host: "docs.example.org", header: http.Header{"Sec-Fetch-Site": {"cross-site"}}, status: http.StatusForbidden},
+		{name: "no browser headers", host: "docs.example.org", status: http.StatusOK},
+	}
+	handler := siteOriginOnly(pages)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/@v1/_progress", nil)
+			r.Host = tt.host
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// aliasesFile maps version aliases to versions, it is read from the latest version of a site
+const aliasesFile = "_aliases"
+
+const (
+	// aliasRedirect redirects aliases to the URL of the version they point to
+	aliasRedirect = "redirect"
+	// aliasRewrite serves the version an alias points to at the URL of the alias
+	aliasRewrite = "rewrite"
+)
+
+func validateAliasMode(mode string) error {
+	if mode != aliasRedirect && mode != aliasRewrite {
+		return fmt.Errorf("invalid alias mode %q, expected %s or %s", mode, aliasRedirect, aliasRewrite)
+	}
+	return nil
+}
+
+// parseAliases parses an _aliases file:
+//
+//	# alias  version
+//	stable   v2.3.1
+//	lts      v1.9.4
+func parseAliases(data []byte) map[string]string {
+	aliases := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || strings.ContainsAny(text, "/@") {
+			slog.Warn("skipping malformed alias", "line", line, "alias", text)
+			continue
+		}
+		aliases[fields[0]] = fields[1]
+	}
+	return aliases
+}
+
+// redirectAlias redirects a page of an alias to the same page of the version it points to,
+// the base of the alias ends with @alias/
+func redirectAlias(w http.ResponseWriter, r *http.Request, pr pageRequest, version string) {
+	base := strings.TrimSuffix(pr.Base, pr.Version+"/")
+	target := url.URL{Path: base + version + "/" + pr.Path, RawQuery: r.URL.RawQuery}
+	// aliases move, so the redirect is not cached
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"code.gitea.io/sdk/gitea"
@@ -18,6 +21,27 @@ import (
 	"golang.org/x/oauth2"
 )
 
+const (
+	// domainUser and domainHost are claims of the access cookie of a custom domain or an owner subdomain,
+	// domainHandoff marks the short-lived token which is exchanged for the cookie. The cookie carries
+	// neither the session nor consts.UserID, so it is no session on the pages server or other hosts.
+	domainUser    = "domain_user"
+	domainHost    = "domain_host"
+	domainHandoff = "domain_handoff"
+	handoffTTL    = time.Minute
+	// domainCookieTTL is how long the access cookie of a domain is valid
+	domainCookieTTL = 24 * time.Hour
+)
+
+// localPath returns ret if it is a path on the same host, "/" otherwise:
+// "//host" and "/\\host" are treated as hosts by browsers
+func localPath(ret string) string {
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") || strings.HasPrefix(ret, "/\\") {
+		return "/"
+	}
+	return ret
+}
+
 type AuthInfo struct {
 	CookieName string `cli:"usage:'name of cookie for oauth state',default:'__i_love_pages_server'"`
 	Secret     string `cli:"usage:'secret for auth',default:'CHANGEME'"`
@@ -29,6 +53,8 @@ type AuthInfo struct {
 	State struct {
 		oauthStateVerrifier func(http.Handler) http.Handler
 		routes              func(r chi.Router)
+		domainRoutes        func(r chi.Router)
+		domainUser          func(http.Handler) http.Handler
 		oauthConfig         *oauth2.Config
 		tokenAuth           *jwtauth.JWTAuth
 	} `cli:"-"`
@@ -131,6 +157,47 @@ func (ai *AuthInfo) Initialize(pages PagesInfo, giteaInfo GiteaInfo, db *databas
 			u := oauthConfig.AuthCodeURL(tokenString)
 			http.Redirect(w, r, u, http.StatusTemporaryRedirect)
 		})
+		r.With(
+			ai.State.oauthStateVerrifier,
+			db.UserSessionFromToken,
+		).Get("/handoff", func(w http.ResponseWriter, r *http.Request) {
+			ret, err := url.Parse(r.URL.Query().Get("return"))
+			if err != nil || (ret.Scheme != "http" && ret.Scheme != "https") {
+				http.Error(w, "invalid return url", http.StatusBadRequest)
+				return
+			}
+			domain := hostName(ret.Host)
+			if ok, err := isSitesHost(pages, db, domain); err != nil || !ok {
+				slog.Warn("handoff to unknown domain", "domain", domain, "err", err)
+				http.Error(w, "unknown domain", http.StatusBadRequest)
+				return
+			}
+			session, err := database.UserSessionFromContext(r.Context())
+			if err != nil || session == (types.UserSession{}) {
+				login := url.Values{"redirect": {r.URL.RequestURI()}}
+				http.Redirect(w, r, "/_auth/login?"+login.Encode(), http.StatusTemporaryRedirect)
+				return
+			}
+			handoffClaims := map[string]any{
+				domainUser:    strconv.FormatInt(int64(session.GiteaUID), 10),
+				domainHost:    domain,
+				domainHandoff: true,
+			}
+			jwtauth.SetExpiryIn(handoffClaims, handoffTTL)
+			_, token, err := ai.State.tokenAuth.Encode(handoffClaims)
+			if err != nil {
+				slog.Error("failed to encode handoff token", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			accept := url.URL{
+				Scheme:   ret.Scheme,
+				Host:     ret.Host,
+				Path:     "/_auth/handoff/accept",
+				RawQuery: url.Values{"token": {token}, "return": {ret.RequestURI()}}.Encode(),
+			}
+			http.Redirect(w, r, accept.String(), http.StatusTemporaryRedirect)
+		})
 		r.With(ai.State.oauthStateVerrifier).Get("/callback", func(w http.ResponseWriter, r *http.Request) {
 			_, claims, err := jwtauth.FromContext(r.Context())
 			if err != nil {
@@ -237,4 +304,77 @@ func (ai *AuthInfo) Initialize(pages PagesInfo, giteaInfo GiteaInfo, db *databas
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		})
 	}
+	// verifyDomainToken checks a handoff token or an access cookie for the host and returns the user
+	verifyDomainToken := func(token, host string, handoff bool) (types.GiteaUID, error) {
+		t, err := jwtauth.VerifyToken(ai.State.tokenAuth, token)
+		if err != nil {
+			return 0, err
+		}
+		claims := t.PrivateClaims()
+		if claimed, _ := claims[domainHost].(string); claimed != host {
+			return 0, fmt.Errorf("token is for domain %q", claimed)
+		}
+		if isHandoff, _ := claims[domainHandoff].(bool); isHandoff != handoff {
+			return 0, fmt.Errorf("unexpected token kind")
+		}
+		uid, _ := claims[domainUser].(string)
+		id, err := strconv.ParseInt(uid, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid user in token: %w", err)
+		}
+		return types.GiteaUID(id), nil
+	}
+	// domainRoutes run on custom domains and owner subdomains, the user is handed over from the pages server
+	// by /_auth/handoff with a short-lived token signed by the same secret, which is exchanged for an access
+	// cookie scoped to the domain
+	ai.State.domainRoutes = func(r chi.Router) {
+		r.Get("/handoff/accept", func(w http.ResponseWriter, r *http.Request) {
+			host := hostName(r.Host)
+			uid, err := verifyDomainToken(r.URL.Query().Get("token"), host, true)
+			if err != nil {
+				slog.Error("failed to verify handoff token", "host", host, "err", err)
+				http.Error(w, "invalid handoff token", http.StatusBadRequest)
+				return
+			}
+			cookieClaims := map[string]any{
+				domainUser: strconv.FormatInt(int64(uid), 10),
+				domainHost: host,
+			}
+			jwtauth.SetExpiryIn(cookieClaims, domainCookieTTL)
+			_, cookie, err := ai.State.tokenAuth.Encode(cookieClaims)
+			if err != nil {
+				slog.Error("failed to encode domain cookie", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     ai.CookieName,
+				Value:    cookie,
+				MaxAge:   int(domainCookieTTL.Seconds()),
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   pages.Secure(),
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, localPath(r.URL.Query().Get("return")), http.StatusTemporaryRedirect)
+		})
+	}
+	// domainUser puts the user of the access cookie of the domain into the context like db.UserFromUserSession
+	ai.State.domainUser = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var user types.User
+			cookie, err := r.Cookie(ai.CookieName)
+			if err == nil {
+				var uid types.GiteaUID
+				uid, err = verifyDomainToken(cookie.Value, hostName(r.Host), false)
+				if err == nil {
+					user, _, err = db.Users().Get(uid)
+				}
+			}
+			if err != nil {
+				slog.Info("domain access cookie is missing or invalid", "host", r.Host, "err", err)
+			}
+			next.ServeHTTP(w, r.WithContext(database.NewUserContext(r.Context(), user, err)))
+		})
+	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+
+	// minCompressSize is the size of the smallest file worth compressing
+	minCompressSize = 512
+	// brotliLevel trades some ratio for speed, the best levels are too slow for large archives
+	brotliLevel = 5
+)
+
+// encodingExtensions are extensions of precompressed siblings shipped in archives
+var encodingExtensions = map[string]string{
+	".gz": encodingGzip,
+	".br": encodingBrotli,
+}
+
+// preferredEncodings are used in this order when the client accepts several with the same weight
+var preferredEncodings = []string{encodingBrotli, encodingGzip}
+
+// compressible reports whether the file is worth storing compressed variants of
+func compressible(name string) bool {
+	switch ext := path.Ext(name); ext {
+	case ".wasm", ".map", ".ttf", ".otf", ".ico":
+		return true
+	case "":
+		return false
+	default:
+		mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+		return strings.HasPrefix(mediaType, "text/") ||
+			strings.Contains(mediaType, "javascript") ||
+			strings.Contains(mediaType, "json") ||
+			strings.Contains(mediaType, "xml")
+	}
+}
+
+func compress(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case encodingGzip:
+		gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = gw
+	case encodingBrotli:
+		w = brotli.NewWriterLevel(&buf, brotliLevel)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chooseEncoding picks the best of the available encodings acceptable according to Accept-Encoding,
+// an empty string means identity
+func chooseEncoding(acceptEncoding string, available map[string]types.PageSHA256) string {
+	if len(available) == 0 || acceptEncoding == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range preferredEncodings {
+		if _, ok := available[enc]; !ok {
+			continue
+		}
+		q, ok := weights[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
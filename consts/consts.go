@@ -6,6 +6,10 @@ const (
 
 	ThisIsAGiteaWebhook = "this is a gitea webhook"
 
+	GiteaSignatureHeader = "X-Gitea-Signature"
+	GiteaEventHeader     = "X-Gitea-Event"
+	GiteaDeliveryHeader  = "X-Gitea-Delivery"
+
 	PagesBranch       = "gh-pages"
 	PagesBranchPrefix = "gh-pages-"
 	PagesLabelPrefix  = "pages-"
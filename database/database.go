@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/ASMfreaK/pages-server/pages-server/consts"
 	"github.com/ASMfreaK/pages-server/pages-server/database/sharedbbolt"
@@ -15,6 +16,7 @@ import (
 	"github.com/philippgille/gokv"
 	"github.com/philippgille/gokv/encoding"
 	"github.com/philippgille/gokv/syncmap"
+	bolt "go.etcd.io/bbolt"
 )
 
 func key(k any) string {
@@ -32,6 +34,16 @@ type Store[K /*stringlike*/ any, T any] interface {
 	Set(k K, v T) error
 	Get(k K) (value T, found bool, err error)
 	Delete(k K) error
+	// DeleteAll deletes several keys at once.
+	DeleteAll(ks []K) error
+	// ForEach calls fn for every stored value, fn must not modify the store.
+	ForEach(fn func(k string, v T) error) error
+	// ForEachKey calls fn for every key with the size of the encoded value, values are not read.
+	// fn must not modify the store.
+	ForEachKey(fn func(k string, size int) error) error
+	// Update reads and changes the value of k atomically, fn returns the new value
+	// and whether to store it. fn must not modify the store.
+	Update(k K, fn func(v T, found bool) (T, bool, error)) error
 	Close() error
 }
 
@@ -56,16 +68,105 @@ func (s *store[K, T]) Delete(k K) error {
 	return s.store.Delete(key(k))
 }
 
+type allDeleter interface {
+	DeleteAll(keys []string) error
+}
+
+func (s *store[K, T]) DeleteAll(ks []K) error {
+	keys := make([]string, 0, len(ks))
+	for _, k := range ks {
+		keys = append(keys, key(k))
+	}
+	if ad, ok := s.store.(allDeleter); ok {
+		return ad.DeleteAll(keys)
+	}
+	for _, k := range keys {
+		if err := s.store.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type forEacher interface {
+	ForEach(fn func(k string, decode func(v any) error) error) error
+}
+
+func (s *store[K, T]) ForEach(fn func(k string, v T) error) error {
+	fe, ok := s.store.(forEacher)
+	if !ok {
+		return errors.New("store does not support iteration")
+	}
+	return fe.ForEach(func(k string, decode func(v any) error) error {
+		var v T
+		if err := decode(&v); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", k, err)
+		}
+		return fn(k, v)
+	})
+}
+
+type keyForEacher interface {
+	ForEachKey(fn func(k string, size int) error) error
+}
+
+func (s *store[K, T]) ForEachKey(fn func(k string, size int) error) error {
+	fe, ok := s.store.(keyForEacher)
+	if !ok {
+		return errors.New("store does not support iteration")
+	}
+	return fe.ForEachKey(fn)
+}
+
+type updater interface {
+	Update(k string, fn func(found bool, decode func(v any) error) (any, error)) error
+}
+
+func (s *store[K, T]) Update(k K, fn func(v T, found bool) (T, bool, error)) error {
+	u, ok := s.store.(updater)
+	if !ok {
+		return errors.New("store does not support updates")
+	}
+	return u.Update(key(k), func(found bool, decode func(v any) error) (any, error) {
+		var v T
+		if found {
+			if err := decode(&v); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", key(k), err)
+			}
+		}
+		v, write, err := fn(v, found)
+		if err != nil || !write {
+			return nil, err
+		}
+		return v, nil
+	})
+}
+
 func (s *store[K, T]) Close() error {
 	return s.store.Close()
 }
 
 type Database struct {
-	userSessions  Store[ulid.ULID, types.UserSession]
-	users         Store[types.GiteaUID, types.User]
-	repoPages     Store[types.Repo, types.RepoInfo]
-	pagesMetadata Store[types.PagesSHA256, types.Pages]
-	pagesData     Store[types.PageSHA256, []byte]
+	userSessions   Store[ulid.ULID, types.UserSession]
+	users          Store[types.GiteaUID, types.User]
+	repoPages      Store[types.Repo, types.RepoInfo]
+	repoHooks      Store[types.Repo, types.RepoHook]
+	repoSettings   Store[types.Repo, types.RepoSettings]
+	repoPins       Store[types.Repo, types.RepoPin]
+	servedVersions Store[string, types.ServedVersion]
+	fetchProgress  Store[string, types.FetchProgress]
+	fetchFailures  Store[string, types.FetchFailure]
+	customDomains  Store[string, types.CustomDomain]
+	siteOrigins    Store[string, types.Repo]
+	pagesMetadata  Store[types.PagesSHA256, types.Pages]
+	pagesConfig    Store[types.PagesSHA256, types.PagesConfig]
+	pagesData      Store[types.PageSHA256, []byte]
+	queueJobs      Store[string, QueuedJob]
+	queueDedup     Store[string, dedupValue]
+	queueDead      Store[string, QueuedJob]
+
+	// gc is held for writing by the garbage collector and for reading while pages are stored
+	gc sync.RWMutex
 }
 
 type noopEncoding struct{}
@@ -81,8 +182,14 @@ func (noopEncoding) Unmarshal(data []byte, v any) error {
 
 var _ encoding.Codec = (*noopEncoding)(nil)
 
+// ErrLocked is returned by New while another process, usually the running server, uses the database
+var ErrLocked = errors.New("database is used by another process")
+
 func New(params Params) (*Database, error) {
 	db, err := sharedbbolt.NewSharedState(params.Filename)
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%w: %w", ErrLocked, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -100,6 +207,13 @@ func New(params Params) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	pagesConfig, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "pages-config",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
 	repoPages, err := db.NewStore(sharedbbolt.Options{
 		BucketName: "repo-pages",
 		Codec:      encoding.JSON,
@@ -107,6 +221,55 @@ func New(params Params) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	repoHooks, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "repo-hooks",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	repoSettings, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "repo-settings",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	repoPins, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "repo-pins",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	servedVersions, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "served-versions",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fetchFailures, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "fetch-failures",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	customDomains, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "custom-domains",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	siteOrigins, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "site-origins",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
 	users, err := db.NewStore(sharedbbolt.Options{
 		BucketName: "users",
 		Codec:      encoding.JSON,
@@ -114,6 +277,27 @@ func New(params Params) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	queueJobs, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "queue-jobs",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	queueDedup, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "queue-dedup",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	queueDead, err := db.NewStore(sharedbbolt.Options{
+		BucketName: "queue-dead",
+		Codec:      encoding.JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
 	return &Database{
 		userSessions: &store[ulid.ULID, types.UserSession]{
 			store: syncmap.NewStore(
@@ -122,10 +306,29 @@ func New(params Params) (*Database, error) {
 				},
 			),
 		},
-		users:         &store[types.GiteaUID, types.User]{users},
-		repoPages:     &store[types.Repo, types.RepoInfo]{repoPages},
-		pagesMetadata: &store[types.PagesSHA256, types.Pages]{pagesMetadata},
-		pagesData:     &store[types.PageSHA256, []byte]{pagesData},
+		// progress of running fetches does not survive restarts
+		fetchProgress: &store[string, types.FetchProgress]{
+			store: syncmap.NewStore(
+				syncmap.Options{
+					Codec: encoding.JSON,
+				},
+			),
+		},
+		users:          &store[types.GiteaUID, types.User]{users},
+		repoPages:      &store[types.Repo, types.RepoInfo]{repoPages},
+		repoHooks:      &store[types.Repo, types.RepoHook]{repoHooks},
+		repoSettings:   &store[types.Repo, types.RepoSettings]{repoSettings},
+		repoPins:       &store[types.Repo, types.RepoPin]{repoPins},
+		servedVersions: &store[string, types.ServedVersion]{servedVersions},
+		fetchFailures:  &store[string, types.FetchFailure]{fetchFailures},
+		customDomains:  &store[string, types.CustomDomain]{customDomains},
+		siteOrigins:    &store[string, types.Repo]{siteOrigins},
+		pagesMetadata:  &store[types.PagesSHA256, types.Pages]{pagesMetadata},
+		pagesConfig:    &store[types.PagesSHA256, types.PagesConfig]{pagesConfig},
+		pagesData:      &store[types.PageSHA256, []byte]{pagesData},
+		queueJobs:      &store[string, QueuedJob]{queueJobs},
+		queueDedup:     &store[string, dedupValue]{queueDedup},
+		queueDead:      &store[string, QueuedJob]{queueDead},
 	}, nil
 }
 
@@ -134,9 +337,21 @@ func (db *Database) Close() error {
 		db.userSessions.Close(),
 		db.users.Close(),
 		db.repoPages.Close(),
+		db.repoHooks.Close(),
+		db.repoSettings.Close(),
+		db.repoPins.Close(),
+		db.servedVersions.Close(),
+		db.fetchProgress.Close(),
+		db.fetchFailures.Close(),
+		db.customDomains.Close(),
+		db.siteOrigins.Close(),
 		db.pagesMetadata.Close(),
+		db.pagesConfig.Close(),
 		db.pagesData.Close(),
-	)
+		db.queueJobs.Close(),
+		db.queueDedup.Close(),
+		db.queueDead.Close(),
+	).ErrorOrNil()
 }
 
 func (db *Database) UserSessions() Store[ulid.ULID, types.UserSession] {
@@ -151,10 +366,52 @@ func (db *Database) RepoPages() Store[types.Repo, types.RepoInfo] {
 	return db.repoPages
 }
 
+func (db *Database) RepoHooks() Store[types.Repo, types.RepoHook] {
+	return db.repoHooks
+}
+
+func (db *Database) RepoSettings() Store[types.Repo, types.RepoSettings] {
+	return db.repoSettings
+}
+
+// RepoPins keep latest versions pinned by repository administrators
+func (db *Database) RepoPins() Store[types.Repo, types.RepoPin] {
+	return db.repoPins
+}
+
+// ServedVersions keep versions last served for requested version names of repositories
+func (db *Database) ServedVersions() Store[string, types.ServedVersion] {
+	return db.servedVersions
+}
+
+// FetchProgress keeps the progress of running fetches of repositories and versions in memory
+func (db *Database) FetchProgress() Store[string, types.FetchProgress] {
+	return db.fetchProgress
+}
+
+// FetchFailures keep the last failures of fetches of repositories and versions
+func (db *Database) FetchFailures() Store[string, types.FetchFailure] {
+	return db.fetchFailures
+}
+
+// CustomDomains maps lowercase host names without port to repositories
+func (db *Database) CustomDomains() Store[string, types.CustomDomain] {
+	return db.customDomains
+}
+
+// SiteOrigins maps subdomain labels of isolated site origins to repositories
+func (db *Database) SiteOrigins() Store[string, types.Repo] {
+	return db.siteOrigins
+}
+
 func (db *Database) PagesMetadata() Store[types.PagesSHA256, types.Pages] {
 	return db.pagesMetadata
 }
 
+func (db *Database) PagesConfig() Store[types.PagesSHA256, types.PagesConfig] {
+	return db.pagesConfig
+}
+
 func (db *Database) PagesData() Store[types.PageSHA256, []byte] {
 	return db.pagesData
 }
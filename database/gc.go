@@ -0,0 +1,147 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+// GCReport describes what a garbage collection removed or, in a dry run, would remove
+type GCReport struct {
+	DryRun       bool          `json:"dry_run"`
+	LiveVersions int           `json:"live_versions"`
+	LiveBlobs    int           `json:"live_blobs"`
+	Versions     int           `json:"versions"`
+	Blobs        int           `json:"blobs"`
+	Bytes        int64         `json:"bytes"`
+	Duration     time.Duration `json:"duration_ns"`
+}
+
+// StorePages stores pages of a version. store is expected to write the page blobs,
+// the collector does not run until the returned metadata and configuration are saved.
+func (db *Database) StorePages(sha types.PagesSHA256, store func() (types.Pages, types.PagesConfig, error)) error {
+	db.gc.RLock()
+	defer db.gc.RUnlock()
+	files, config, err := store()
+	if err != nil {
+		return err
+	}
+	// metadata marks the version as fetched, so the configuration goes first
+	if err = db.pagesConfig.Set(sha, config); err != nil {
+		return err
+	}
+	return db.pagesMetadata.Set(sha, files)
+}
+
+// CollectGarbage removes page metadata which is not referenced by any repository
+// and page blobs which are not referenced by any live metadata. Served versions keep their pages
+// until they are stale for longer than maxStaleness, expired ones are removed.
+func (db *Database) CollectGarbage(dryRun bool, maxStaleness time.Duration) (GCReport, error) {
+	report := GCReport{DryRun: dryRun}
+	start := time.Now()
+	db.gc.Lock()
+	defer db.gc.Unlock()
+
+	// mark
+	liveVersions := make(map[types.PagesSHA256]struct{})
+	err := db.repoPages.ForEach(func(_ string, info types.RepoInfo) error {
+		liveVersions[info.Latest.SHA] = struct{}{}
+		for _, v := range info.Versions {
+			liveVersions[v.SHA] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	// versions served while newer ones are fetched
+	var expired []string
+	err = db.servedVersions.ForEach(func(k string, served types.ServedVersion) error {
+		if !served.StaleSince.IsZero() && start.Sub(served.StaleSince) > maxStaleness {
+			expired = append(expired, k)
+			return nil
+		}
+		liveVersions[served.Version.SHA] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list served versions: %w", err)
+	}
+	if len(expired) != 0 && !dryRun {
+		slog.Info("removing expired served versions", "count", len(expired))
+		if err := db.servedVersions.DeleteAll(expired); err != nil {
+			return report, fmt.Errorf("failed to remove expired served versions: %w", err)
+		}
+	}
+	liveBlobs := make(map[types.PageSHA256]struct{})
+	var deadVersions []types.PagesSHA256
+	err = db.pagesMetadata.ForEach(func(k string, pages types.Pages) error {
+		sha := types.PagesSHA256(k)
+		if _, ok := liveVersions[sha]; !ok {
+			deadVersions = append(deadVersions, sha)
+			return nil
+		}
+		report.LiveVersions++
+		for _, file := range pages {
+			liveBlobs[file.SHA] = struct{}{}
+			for _, sha := range file.Encodings {
+				liveBlobs[sha] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list pages metadata: %w", err)
+	}
+	report.LiveBlobs = len(liveBlobs)
+	var deadConfigs []types.PagesSHA256
+	err = db.pagesConfig.ForEach(func(k string, _ types.PagesConfig) error {
+		if _, ok := liveVersions[types.PagesSHA256(k)]; !ok {
+			deadConfigs = append(deadConfigs, types.PagesSHA256(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list pages config: %w", err)
+	}
+
+	// sweep
+	var deadBlobs []types.PageSHA256
+	// blobs are large, only their keys are read while StorePages waits for the collector
+	err = db.pagesData.ForEachKey(func(k string, size int) error {
+		sha := types.PageSHA256(k)
+		if _, ok := liveBlobs[sha]; ok {
+			return nil
+		}
+		deadBlobs = append(deadBlobs, sha)
+		report.Bytes += int64(size)
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list pages data: %w", err)
+	}
+	report.Versions = len(deadVersions)
+	report.Blobs = len(deadBlobs)
+	if !dryRun {
+		if err = db.pagesMetadata.DeleteAll(deadVersions); err != nil {
+			return report, fmt.Errorf("failed to delete pages metadata: %w", err)
+		}
+		if err = db.pagesConfig.DeleteAll(deadConfigs); err != nil {
+			return report, fmt.Errorf("failed to delete pages config: %w", err)
+		}
+		if err = db.pagesData.DeleteAll(deadBlobs); err != nil {
+			return report, fmt.Errorf("failed to delete pages data: %w", err)
+		}
+	}
+	report.Duration = time.Since(start)
+	slog.Info("garbage collected",
+		"dryRun", dryRun,
+		"versions", report.Versions,
+		"blobs", report.Blobs,
+		"bytes", report.Bytes,
+		"duration", report.Duration,
+	)
+	return report, nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestCollectGarbageServedVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		staleSince time.Duration
+		live       bool
+		bytes      int64
+	}{
+		{name: "current", live: true},
+		{name: "recently superseded", staleSince: time.Minute, live: true},
+		{name: "expired", staleSince: 2 * time.Hour, bytes: int64(len("old"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := New(Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			sha := types.PagesSHA256("old")
+			err = db.StorePages(sha, func() (types.Pages, types.PagesConfig, error) {
+				return types.Pages{{Name: "index.html", SHA: "index"}}, types.PagesConfig{}, db.pagesData.Set("index", []byte("old"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			served := types.ServedVersion{Version: types.Version{Version: "v1", SHA: sha}}
+			if tt.staleSince != 0 {
+				served.StaleSince = time.Now().Add(-tt.staleSince)
+			}
+			if err := db.servedVersions.Set("owner/repo@", served); err != nil {
+				t.Fatal(err)
+			}
+			report, err := db.CollectGarbage(false, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if live := report.LiveVersions == 1; live != tt.live {
+				t.Errorf("live = %v, want %v, report %+v", live, tt.live, report)
+			}
+			if report.Bytes != tt.bytes {
+				t.Errorf("removed %d bytes, want %d", report.Bytes, tt.bytes)
+			}
+			_, kept, err := db.servedVersions.Get("owner/repo@")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept != tt.live {
+				t.Errorf("served version kept = %v, want %v", kept, tt.live)
+			}
+		})
+	}
+}
@@ -2,42 +2,302 @@ package database
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	gq "github.com/golang-queue/queue"
-	gqc "github.com/golang-queue/queue/core"
-	"github.com/philippgille/gokv/encoding"
-	"github.com/philippgille/gokv/syncmap"
+	"github.com/oklog/ulid/v2"
 )
 
 type Params struct {
 	Filename string `cli:"usage:'path to database',default:'pages-server.db'"`
 }
 
+type QueueParams struct {
+	RetryAttempts   int           `cli:"usage:'how many times a failed fetch job is tried before it is moved to dead jobs',default:'5'"`
+	RetryBackoff    time.Duration `cli:"usage:'delay before the first retry of a failed fetch job, doubled on every next retry',default:'10s'"`
+	RetryMaxBackoff time.Duration `cli:"usage:'maximum delay between retries of a failed fetch job',default:'10m'"`
+	RetryOverride   []string      `cli:"usage:'retry policy of a single queue as QUEUE=ATTEMPTS[/BACKOFF[/MAX_BACKOFF]], omitted values are taken from the flags above'"`
+}
+
+func (p QueueParams) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: p.RetryAttempts,
+		Backoff:     p.RetryBackoff,
+		MaxBackoff:  p.RetryMaxBackoff,
+		Jitter:      DefaultRetryPolicy.Jitter,
+	}
+}
+
+// RetryPolicies returns the retry policy of every queue, overrides are parsed from RetryOverride
+func (p QueueParams) RetryPolicies() (RetryPolicies, error) {
+	ret := RetryPolicies{Default: p.RetryPolicy(), Queues: map[string]RetryPolicy{}}
+	for _, s := range p.RetryOverride {
+		queue, policy, err := parseRetryOverride(s, ret.Default)
+		if err != nil {
+			return ret, err
+		}
+		ret.Queues[queue] = policy
+	}
+	return ret, nil
+}
+
+// parseRetryOverride parses QUEUE=ATTEMPTS[/BACKOFF[/MAX_BACKOFF]], empty values are taken from base
+func parseRetryOverride(s string, base RetryPolicy) (string, RetryPolicy, error) {
+	queue, spec, ok := strings.Cut(s, "=")
+	fields := strings.Split(spec, "/")
+	if !ok || queue == "" || len(fields) > 3 {
+		return "", base, fmt.Errorf("invalid retry override %q, expected QUEUE=ATTEMPTS[/BACKOFF[/MAX_BACKOFF]]", s)
+	}
+	policy := base
+	if fields[0] != "" {
+		attempts, err := strconv.Atoi(fields[0])
+		if err != nil || attempts < 1 {
+			return "", base, fmt.Errorf("invalid attempts in retry override %q", s)
+		}
+		policy.MaxAttempts = attempts
+	}
+	for i, d := range []*time.Duration{&policy.Backoff, &policy.MaxBackoff} {
+		if len(fields) <= i+1 || fields[i+1] == "" {
+			continue
+		}
+		v, err := time.ParseDuration(fields[i+1])
+		if err != nil || v < 0 {
+			return "", base, fmt.Errorf("invalid delay in retry override %q", s)
+		}
+		*d = v
+	}
+	return queue, policy, nil
+}
+
+// RetryPolicies are the default retry policy and overrides of single queues
+type RetryPolicies struct {
+	Default RetryPolicy
+	Queues  map[string]RetryPolicy
+}
+
+// For returns the retry policy of the queue
+func (p RetryPolicies) For(queue string) RetryPolicy {
+	if policy, ok := p.Queues[queue]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Apply sets the retry policy of every task, overrides of queues without a task are an error
+func (p RetryPolicies) Apply(tasks ...Task) ([]Task, error) {
+	ret := make([]Task, 0, len(tasks))
+	known := map[string]bool{}
+	for _, t := range tasks {
+		qn := t.TaskElement().QueueName()
+		known[qn] = true
+		ret = append(ret, WithRetryPolicy(t, p.For(qn)))
+	}
+	for queue := range p.Queues {
+		if !known[queue] {
+			return nil, fmt.Errorf("%w %q in retry override", ErrUnknownQueue, queue)
+		}
+	}
+	return ret, nil
+}
+
+// RetryPolicy describes how failed jobs of a queue are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of runs before the job is moved to dead jobs
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles with every retry
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay which is randomized
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used for tasks without a policy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     10 * time.Second,
+	MaxBackoff:  10 * time.Minute,
+	Jitter:      0.2,
+}
+
+// Delay returns the delay before the next run after attempt runs have failed
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// JobAttempt is the run of a job, runners find it in their context
+type JobAttempt struct {
+	// Attempt counts runs of the job including the current one
+	Attempt     int
+	MaxAttempts int
+}
+
+// Final reports whether the job is moved to dead jobs if this run fails
+func (a JobAttempt) Final() bool {
+	return a.Attempt >= a.MaxAttempts
+}
+
+type jobAttemptCtxKey struct{}
+
+// JobAttemptFromContext returns the run of the job passed to its runner
+func JobAttemptFromContext(ctx context.Context) (JobAttempt, bool) {
+	a, ok := ctx.Value(jobAttemptCtxKey{}).(JobAttempt)
+	return a, ok
+}
+
+// workersPerQueue is the number of jobs of one queue that can run concurrently
+const workersPerQueue = 2
+
+// dedupRetryDelay is how long a job waits for the running job with the same dedup key
+var dedupRetryDelay = 10 * time.Second
+
+// dedupValue is stored while a job with the dedup key runs, Posted is when the run started
 type dedupValue struct {
 	Running bool
 	Posted  time.Time
 }
 
+// QueuedJob is a job stored in the queue bucket until it is processed
+// and in the dead jobs bucket once it runs out of attempts
+type QueuedJob struct {
+	ID        string    `json:"id"`
+	Queue     string    `json:"queue"`
+	Posted    time.Time `json:"posted"`
+	Job       string    `json:"job"`
+	Attempts  int       `json:"attempts,omitempty"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	FailedAt  time.Time `json:"failed_at,omitempty"`
+}
+
+// Key is the key of the job in the queue bucket.
+// Keys of one queue are sorted by the time jobs were posted.
+func (j *QueuedJob) Key() string {
+	return queueKey(j.Queue, j.ID)
+}
+
+func queueKey(queue, k string) string {
+	return fmt.Sprintf("%s/%s", queue, k)
+}
+
 type queueState struct {
-	queue *gq.Queue
-	dedup Store[string, dedupValue]
+	name   string
+	task   Task
+	policy RetryPolicy
+
+	mu        sync.Mutex
+	pending   []string
+	wake      chan struct{}
+	running   map[string]*runningJob
+	completed []JobStatus
 }
 
+func (qs *queueState) push(key string) {
+	qs.mu.Lock()
+	qs.pending = append(qs.pending, key)
+	qs.mu.Unlock()
+	qs.signal()
+}
+
+// pushAt makes the job available to workers not earlier than at
+func (qs *queueState) pushAt(key string, at time.Time) {
+	d := time.Until(at)
+	if d <= 0 {
+		qs.push(key)
+		return
+	}
+	time.AfterFunc(d, func() { qs.push(key) })
+}
+
+func (qs *queueState) pop() (string, bool) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if len(qs.pending) == 0 {
+		return "", false
+	}
+	key := qs.pending[0]
+	qs.pending = qs.pending[1:]
+	if len(qs.pending) != 0 {
+		// let other workers pick up the rest
+		defer qs.signal()
+	}
+	return key, true
+}
+
+func (qs *queueState) signal() {
+	select {
+	case qs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Queue is a persistent job queue. Jobs are stored in the database when enqueued
+// and removed once processed, so jobs which were pending or running when
+// the server stopped are replayed on the next start.
 type Queue struct {
+	db     *Database
+	jobs   Store[string, QueuedJob]
+	dedup  Store[string, dedupValue]
 	queues map[string]*queueState
+
+	cancel   context.CancelFunc
+	stopping chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
 }
 
+// Close interrupts running jobs and stops the workers.
+// Interrupted jobs stay stored and are replayed on the next start.
 func (q *Queue) Close() error {
-	for _, q := range q.queues {
-		q.queue.Release()
-	}
+	q.stop()
+	q.cancel()
+	q.wg.Wait()
 	return nil
 }
 
+// Shutdown stops taking new jobs and waits for running jobs to finish until ctx is done,
+// then interrupts the jobs which are still running like Close does.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stop()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		slog.Warn("interrupting running jobs", "err", ctx.Err())
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) stop() {
+	q.stopOnce.Do(func() {
+		close(q.stopping)
+	})
+}
+
 var queueCtxKey = ctxKey{"liteq"}
 
 func QueueFromContext(ctx context.Context) *Queue {
@@ -48,75 +308,290 @@ func QueueFromContext(ctx context.Context) *Queue {
 	return q.(*Queue)
 }
 
-type jobT struct {
-	Posted time.Time
-	Job    string
-}
+func NewQueue(ctx context.Context, db *Database, tasks ...Task) (*Queue, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	ret := &Queue{
+		db:       db,
+		jobs:     db.queueJobs,
+		dedup:    db.queueDedup,
+		queues:   make(map[string]*queueState),
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+	}
+	ctx = context.WithValue(ctx, queueCtxKey, ret)
+
+	for _, task := range tasks {
+		qn := task.TaskElement().QueueName()
+		policy := DefaultRetryPolicy
+		if rt, ok := task.(RetryingTask); ok {
+			policy = rt.RetryPolicy()
+		}
+		ret.queues[qn] = &queueState{
+			name:    qn,
+			task:    task,
+			policy:  policy,
+			wake:    make(chan struct{}, 1),
+			running: make(map[string]*runningJob),
+		}
+	}
 
-func (j *jobT) Bytes() []byte {
-	b, err := json.Marshal(j)
+	err := ret.replay()
 	if err != nil {
-		panic(err)
+		cancel()
+		return nil, err
 	}
-	return b
+
+	for _, qs := range ret.queues {
+		for range workersPerQueue {
+			ret.wg.Add(1)
+			go func() {
+				defer ret.wg.Done()
+				ret.worker(ctx, qs)
+			}()
+		}
+	}
+	return ret, nil
 }
 
-func NewQueue(_ context.Context, tasks ...Task) (*Queue, error) {
-	ret := &Queue{
-		queues: make(map[string]*queueState),
+// replay restores jobs left from the previous run
+func (q *Queue) replay() error {
+	// nothing is running yet, the runs that were interrupted will be replayed
+	var stale []string
+	err := q.dedup.ForEach(func(k string, _ dedupValue) error {
+		stale = append(stale, k)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list dedup state: %w", err)
+	}
+	for _, k := range stale {
+		if err = q.dedup.Delete(k); err != nil {
+			return fmt.Errorf("failed to reset dedup state: %w", err)
+		}
 	}
 
-	for _, task := range tasks {
-		qn := task.TaskElement().QueueName()
-		qs := &queueState{
-			queue: nil,
-			dedup: &store[string, dedupValue]{
-				syncmap.NewStore(syncmap.Options{
-					Codec: encoding.JSON,
-				}),
-			},
-		}
-		qs.queue = gq.NewPool(2, gq.WithFn(func(ctx context.Context, msg gqc.QueuedMessage) error {
-			ctx = context.WithValue(ctx, queueCtxKey, ret)
-			job, ok := msg.(*jobT)
-			if !ok {
-				if err := json.Unmarshal(msg.Bytes(), &job); err != nil {
-					return err
-				}
-			}
-			slog.Info("starting consumer", "task", qn)
-			te := task.TaskElement()
-			err := te.ParseJob(job.Job)
-			if err != nil {
-				slog.Error("failed to parse job", "task", qn, "job", job.Job, "err", err)
-				return fmt.Errorf("failed to parse job %w", err)
-			}
-			dedupState, ok, _ := qs.dedup.Get(te.DedupingKey())
-			if ok {
-				if job.Posted.Before(dedupState.Posted) {
-					slog.Info("skipping job - task is older than the last run", "task", qn, "job", job.Job, "jobPosted", job.Posted, "another job is running", dedupState.Posted)
-					return nil
-				}
-				if dedupState.Running {
-					slog.Info("skipping job - another job is still running", "task", qn, "job", job.Job)
-					return nil
-				}
-			}
-			_ = qs.dedup.Set(te.DedupingKey(), dedupValue{Posted: time.Now(), Running: true})
-			slog.Info("starting consumer runner", "task", qn, "job", te, "err", err)
-			err = task.Runner(ctx, te)
-			if err != nil {
-				_ = qs.dedup.Delete(te.DedupingKey())
-				slog.Error("task failed", "task", qn, "job", job.Job, "err", err)
-				return err
-			}
-			slog.Info("task finished", "task", qn, "job", job.Job)
-			_ = qs.dedup.Set(te.DedupingKey(), dedupValue{Posted: time.Now(), Running: false})
+	replayed := 0
+	var delayed []QueuedJob
+	err = q.jobs.ForEach(func(k string, job QueuedJob) error {
+		qs, ok := q.queues[job.Queue]
+		if !ok {
+			slog.Warn("job for unknown queue is left in the database", "task", job.Queue, "job", job.Job)
+			return nil
+		}
+		replayed++
+		if job.NotBefore.After(time.Now()) {
+			delayed = append(delayed, job)
 			return nil
-		}))
-		ret.queues[qn] = qs
+		}
+		qs.pending = append(qs.pending, k)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list queued jobs: %w", err)
 	}
-	return ret, nil
+	for _, job := range delayed {
+		q.queues[job.Queue].pushAt(job.Key(), job.NotBefore)
+	}
+	if replayed != 0 {
+		slog.Info("replaying queued jobs", "jobs", replayed)
+	}
+	return nil
+}
+
+func (q *Queue) worker(ctx context.Context, qs *queueState) {
+	for {
+		select {
+		case <-q.stopping:
+			return
+		default:
+		}
+		key, ok := qs.pop()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.stopping:
+				return
+			case <-qs.wake:
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		q.process(ctx, qs, key)
+	}
+}
+
+func (q *Queue) process(ctx context.Context, qs *queueState, key string) {
+	qn := qs.name
+	job, ok, err := q.jobs.Get(key)
+	if err != nil {
+		slog.Error("failed to get job", "task", qn, "key", key, "err", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if job.NotBefore.After(time.Now()) {
+		qs.pushAt(key, job.NotBefore)
+		return
+	}
+	done := func() {
+		if err := q.jobs.Delete(key); err != nil {
+			slog.Error("failed to remove job", "task", qn, "job", job.Job, "err", err)
+		}
+	}
+	slog.Info("starting consumer", "task", qn)
+	te := qs.task.TaskElement()
+	err = te.ParseJob(job.Job)
+	if err != nil {
+		slog.Error("failed to parse job", "task", qn, "job", job.Job, "err", err)
+		done()
+		return
+	}
+	dedupKey := queueKey(qn, te.DedupingKey())
+	// the dedup state is checked and claimed in one transaction, so two workers never run the same key
+	var claimed dedupValue
+	var busy bool
+	err = q.dedup.Update(dedupKey, func(dedupState dedupValue, found bool) (dedupValue, bool, error) {
+		if found && dedupState.Running {
+			busy = true
+			return dedupState, false, nil
+		}
+		claimed = dedupValue{Posted: time.Now(), Running: true}
+		return claimed, true, nil
+	})
+	if err != nil {
+		q.failed(qs, job, fmt.Errorf("failed to claim dedup state: %w", err))
+		return
+	}
+	if busy {
+		// the running job may have missed the change this job was posted for, so it is run later
+		// unless the running job succeeds and drops it as redundant
+		slog.Info("delaying job - another job is still running", "task", qn, "job", job.Job, "delay", dedupRetryDelay)
+		job.NotBefore = time.Now().Add(dedupRetryDelay)
+		if err := q.jobs.Set(key, job); err != nil {
+			slog.Error("failed to store delayed job", "task", qn, "job", job.Job, "err", err)
+			return
+		}
+		qs.pushAt(key, job.NotBefore)
+		return
+	}
+	slog.Info("starting consumer runner", "task", qn, "job", te)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runCtx = context.WithValue(runCtx, jobAttemptCtxKey{}, JobAttempt{Attempt: job.Attempts + 1, MaxAttempts: qs.policy.MaxAttempts})
+	rj := qs.start(key, job, te.DedupingKey(), cancel)
+	err = qs.task.Runner(runCtx, te)
+	qs.stop(key)
+	switch {
+	case err == nil:
+		slog.Info("task finished", "task", qn, "job", job.Job)
+		done()
+		q.dropRedundant(qs, te.DedupingKey(), claimed.Posted)
+		q.releaseDedup(qn, dedupKey)
+		qs.finished(rj)
+	case ctx.Err() != nil:
+		// the queue is shutting down, keep the job to replay it on the next start
+		slog.Warn("task interrupted", "task", qn, "job", job.Job, "err", err)
+	case rj.cancelled.Load():
+		slog.Info("task cancelled", "task", qn, "job", job.Job)
+		q.releaseDedup(qn, dedupKey)
+		done()
+	default:
+		q.releaseDedup(qn, dedupKey)
+		q.failed(qs, job, err)
+	}
+}
+
+// dropRedundant removes queued jobs with the dedup key posted before the successful run started,
+// the run has already seen what they were posted for
+func (q *Queue) dropRedundant(qs *queueState, dedupKey string, started time.Time) {
+	var redundant []string
+	err := q.jobs.ForEach(func(k string, job QueuedJob) error {
+		if job.Queue == qs.name && job.Posted.Before(started) && qs.dedupKey(job) == dedupKey {
+			redundant = append(redundant, k)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to list queued jobs", "task", qs.name, "err", err)
+		return
+	}
+	for _, k := range redundant {
+		slog.Info("dropping job - posted before the last run", "task", qs.name, "key", k)
+		if err := q.jobs.Delete(k); err != nil {
+			slog.Error("failed to remove job", "task", qs.name, "key", k, "err", err)
+		}
+	}
+}
+
+// releaseDedup lets the next job with the dedup key run
+func (q *Queue) releaseDedup(qn, dedupKey string) {
+	if err := q.dedup.Delete(dedupKey); err != nil {
+		slog.Error("failed to release dedup state", "task", qn, "key", dedupKey, "err", err)
+	}
+}
+
+// failed schedules a retry of the job or moves it to dead jobs
+func (q *Queue) failed(qs *queueState, job QueuedJob, runErr error) {
+	job.Attempts++
+	job.LastError = runErr.Error()
+	if job.Attempts < qs.policy.MaxAttempts {
+		delay := qs.policy.Delay(job.Attempts)
+		job.NotBefore = time.Now().Add(delay)
+		slog.Warn("task failed, will retry", "task", qs.name, "job", job.Job, "attempt", job.Attempts, "delay", delay, "err", runErr)
+		if err := q.jobs.Set(job.Key(), job); err != nil {
+			slog.Error("failed to store job for retry", "task", qs.name, "job", job.Job, "err", err)
+			return
+		}
+		qs.pushAt(job.Key(), job.NotBefore)
+		return
+	}
+	slog.Error("task failed, moving to dead jobs", "task", qs.name, "job", job.Job, "attempts", job.Attempts, "err", runErr)
+	job.NotBefore = time.Time{}
+	job.FailedAt = time.Now()
+	if err := q.db.queueDead.Set(job.Key(), job); err != nil {
+		slog.Error("failed to store dead job", "task", qs.name, "job", job.Job, "err", err)
+	}
+	if err := q.jobs.Delete(job.Key()); err != nil {
+		slog.Error("failed to remove job", "task", qs.name, "job", job.Job, "err", err)
+	}
+}
+
+// DeadJobs lists jobs which ran out of attempts
+func (db *Database) DeadJobs() ([]QueuedJob, error) {
+	var ret []QueuedJob
+	err := db.queueDead.ForEach(func(_ string, job QueuedJob) error {
+		ret = append(ret, job)
+		return nil
+	})
+	return ret, err
+}
+
+var ErrJobNotFound = errors.New("job not found")
+
+// RequeueDeadJob moves the dead job back to the queue bucket.
+// It is picked up by the running queue or replayed on the next start.
+func (db *Database) RequeueDeadJob(key string) (QueuedJob, error) {
+	job, ok, err := db.queueDead.Get(key)
+	if err != nil {
+		return job, err
+	}
+	if !ok {
+		return job, fmt.Errorf("%w: %s", ErrJobNotFound, key)
+	}
+	job.Attempts = 0
+	job.LastError = ""
+	job.FailedAt = time.Time{}
+	// the job must not be skipped as older than the last run
+	job.Posted = time.Now()
+	err = db.queueJobs.Set(job.Key(), job)
+	if err != nil {
+		return job, fmt.Errorf("failed to store job: %w", err)
+	}
+	return job, db.queueDead.Delete(key)
 }
 
 type Task interface {
@@ -125,6 +600,26 @@ type Task interface {
 	TaskElement() TaskElement
 }
 
+// RetryingTask is a Task with its own retry policy
+type RetryingTask interface {
+	Task
+	RetryPolicy() RetryPolicy
+}
+
+type retryingTask struct {
+	Task
+	policy RetryPolicy
+}
+
+func (t *retryingTask) RetryPolicy() RetryPolicy {
+	return t.policy
+}
+
+// WithRetryPolicy sets the retry policy of the task queue
+func WithRetryPolicy(t Task, p RetryPolicy) Task {
+	return &retryingTask{Task: t, policy: p}
+}
+
 type TaskElement interface {
 	QueueName() string
 	ParseJob(string) error
@@ -155,9 +650,23 @@ func FuncTask[T any, PT TaskElementPtr[T]](f func(ctx context.Context, task PT)
 	return &funcTask[T, PT]{f: f}
 }
 
+var ErrUnknownQueue = errors.New("unknown queue")
+
 func (q *Queue) Enqueue(_ context.Context, t TaskElement) error {
-	return q.queues[t.QueueName()].queue.Queue(&jobT{
+	qs, ok := q.queues[t.QueueName()]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownQueue, t.QueueName())
+	}
+	job := QueuedJob{
+		ID:     ulid.Make().String(),
+		Queue:  qs.name,
 		Posted: time.Now(),
 		Job:    t.Job(),
-	})
+	}
+	err := q.jobs.Set(job.Key(), job)
+	if err != nil {
+		return fmt.Errorf("failed to store job: %w", err)
+	}
+	qs.push(job.Key())
+	return nil
 }
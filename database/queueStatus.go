@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// completedHistorySize is the number of recently completed jobs kept for every queue
+const completedHistorySize = 20
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobFailed    JobState = "failed"
+	JobCompleted JobState = "completed"
+)
+
+// JobStatus describes a job for introspection
+type JobStatus struct {
+	QueuedJob
+	Key        string        `json:"key"`
+	DedupKey   string        `json:"dedup_key"`
+	State      JobState      `json:"state"`
+	StartedAt  time.Time     `json:"started_at,omitempty"`
+	FinishedAt time.Time     `json:"finished_at,omitempty"`
+	Duration   time.Duration `json:"duration_ns,omitempty"`
+}
+
+// QueueStatus lists jobs of one queue
+type QueueStatus struct {
+	Name      string      `json:"name"`
+	Queued    []JobStatus `json:"queued"`
+	Running   []JobStatus `json:"running"`
+	Failed    []JobStatus `json:"failed"`
+	Completed []JobStatus `json:"completed"`
+}
+
+type runningJob struct {
+	status    JobStatus
+	cancel    context.CancelFunc
+	cancelled atomic.Bool
+}
+
+func (qs *queueState) start(key string, job QueuedJob, dedupKey string, cancel context.CancelFunc) *runningJob {
+	rj := &runningJob{
+		status: JobStatus{
+			QueuedJob: job,
+			Key:       key,
+			DedupKey:  dedupKey,
+			State:     JobRunning,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.running[key] = rj
+	return rj
+}
+
+func (qs *queueState) stop(key string) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	delete(qs.running, key)
+}
+
+func (qs *queueState) finished(rj *runningJob) {
+	status := rj.status
+	status.State = JobCompleted
+	status.FinishedAt = time.Now()
+	status.Duration = status.FinishedAt.Sub(status.StartedAt)
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.completed = append(qs.completed, status)
+	if len(qs.completed) > completedHistorySize {
+		qs.completed = slices.Delete(qs.completed, 0, len(qs.completed)-completedHistorySize)
+	}
+}
+
+func (qs *queueState) dedupKey(job QueuedJob) string {
+	te := qs.task.TaskElement()
+	if err := te.ParseJob(job.Job); err != nil {
+		return ""
+	}
+	return te.DedupingKey()
+}
+
+// Status lists queued, running, failed and recently completed jobs of every queue
+func (q *Queue) Status() ([]QueueStatus, error) {
+	ret := make([]QueueStatus, 0, len(q.queues))
+	byName := make(map[string]*QueueStatus, len(q.queues))
+	for _, qs := range q.queues {
+		status := QueueStatus{Name: qs.name}
+		qs.mu.Lock()
+		for _, rj := range qs.running {
+			js := rj.status
+			js.Duration = time.Since(js.StartedAt)
+			status.Running = append(status.Running, js)
+		}
+		for i := len(qs.completed) - 1; i >= 0; i-- {
+			status.Completed = append(status.Completed, qs.completed[i])
+		}
+		qs.mu.Unlock()
+		slices.SortFunc(status.Running, func(a, b JobStatus) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+		ret = append(ret, status)
+	}
+	slices.SortFunc(ret, func(a, b QueueStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range ret {
+		byName[ret[i].Name] = &ret[i]
+	}
+
+	var queued, failed []QueuedJob
+	err := q.jobs.ForEach(func(_ string, job QueuedJob) error {
+		queued = append(queued, job)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queued jobs: %w", err)
+	}
+	err = q.db.queueDead.ForEach(func(_ string, job QueuedJob) error {
+		failed = append(failed, job)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead jobs: %w", err)
+	}
+	for _, job := range queued {
+		status, ok := byName[job.Queue]
+		if !ok || slices.ContainsFunc(status.Running, func(js JobStatus) bool { return js.Key == job.Key() }) {
+			continue
+		}
+		status.Queued = append(status.Queued, JobStatus{
+			QueuedJob: job,
+			Key:       job.Key(),
+			DedupKey:  q.queues[job.Queue].dedupKey(job),
+			State:     JobQueued,
+		})
+	}
+	for _, job := range failed {
+		status, ok := byName[job.Queue]
+		if !ok {
+			continue
+		}
+		status.Failed = append(status.Failed, JobStatus{
+			QueuedJob: job,
+			Key:       job.Key(),
+			DedupKey:  q.queues[job.Queue].dedupKey(job),
+			State:     JobFailed,
+		})
+	}
+	return ret, nil
+}
+
+func (q *Queue) queueOf(key string) (*queueState, error) {
+	name, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, key)
+	}
+	qs, ok := q.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownQueue, name)
+	}
+	return qs, nil
+}
+
+// Cancel stops the running job, removes the queued job or discards the dead job
+func (q *Queue) Cancel(key string) error {
+	qs, err := q.queueOf(key)
+	if err != nil {
+		return err
+	}
+	qs.mu.Lock()
+	rj, running := qs.running[key]
+	qs.mu.Unlock()
+	if running {
+		slog.Info("cancelling running job", "task", qs.name, "job", rj.status.Job)
+		rj.cancelled.Store(true)
+		rj.cancel()
+		return nil
+	}
+	if _, ok, _ := q.jobs.Get(key); ok {
+		// a worker that pops the key will find nothing
+		return q.jobs.Delete(key)
+	}
+	if _, ok, _ := q.db.queueDead.Get(key); ok {
+		return q.db.queueDead.Delete(key)
+	}
+	return fmt.Errorf("%w: %s", ErrJobNotFound, key)
+}
+
+// Requeue moves the dead job back to its queue or enqueues a recently completed job again
+func (q *Queue) Requeue(key string) error {
+	qs, err := q.queueOf(key)
+	if err != nil {
+		return err
+	}
+	job, err := q.db.RequeueDeadJob(key)
+	if err == nil {
+		qs.push(job.Key())
+		return nil
+	}
+	if !errors.Is(err, ErrJobNotFound) {
+		return err
+	}
+	qs.mu.Lock()
+	i := slices.IndexFunc(qs.completed, func(js JobStatus) bool { return js.Key == key })
+	if i >= 0 {
+		job = qs.completed[i].QueuedJob
+	}
+	qs.mu.Unlock()
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, key)
+	}
+	te := qs.task.TaskElement()
+	if err = te.ParseJob(job.Job); err != nil {
+		return err
+	}
+	return q.Enqueue(context.Background(), te)
+}
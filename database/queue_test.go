@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database/sharedbbolt"
+	"github.com/oklog/ulid/v2"
+)
+
+// testJob is a job of the test queue, jobs with the same Key are deduplicated
+type testJob struct {
+	Key string
+	N   string
+}
+
+func (j *testJob) QueueName() string   { return "test" }
+func (j *testJob) DedupingKey() string { return j.Key }
+func (j *testJob) Job() string         { return j.Key + "#" + j.N }
+func (j *testJob) ParseJob(s string) error {
+	j.Key, j.N, _ = strings.Cut(s, "#")
+	return nil
+}
+
+func TestQueueDedup(t *testing.T) {
+	db, err := New(Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	delay := dedupRetryDelay
+	dedupRetryDelay = 50 * time.Millisecond
+	defer func() { dedupRetryDelay = delay }()
+
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	q, err := NewQueue(context.Background(), db, FuncTask(func(_ context.Context, j *testJob) error {
+		started <- j.N
+		if j.N == "1" {
+			<-release
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	wait := func(want string) {
+		t.Helper()
+		select {
+		case n := <-started:
+			if n != want {
+				t.Fatalf("job %s started, want %s", n, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("job %s did not start", want)
+		}
+	}
+
+	if err := q.Enqueue(context.Background(), &testJob{Key: "repo", N: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	wait("1")
+	// posted before the run started, it is redundant once the run succeeds
+	stale := QueuedJob{ID: ulid.Make().String(), Queue: "test", Posted: time.Now().Add(-time.Hour), Job: "repo#0", NotBefore: time.Now().Add(time.Hour)}
+	if err := db.queueJobs.Set(stale.Key(), stale); err != nil {
+		t.Fatal(err)
+	}
+	// posted while the run is going on, it has to run afterwards
+	if err := q.Enqueue(context.Background(), &testJob{Key: "repo", N: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * dedupRetryDelay)
+	select {
+	case n := <-started:
+		t.Fatalf("job %s started while another job with the same key was running", n)
+	default:
+	}
+	close(release)
+	wait("2")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var jobs, dedup []string
+		err := errors.Join(
+			db.queueJobs.ForEach(func(k string, _ QueuedJob) error { jobs = append(jobs, k); return nil }),
+			db.queueDedup.ForEach(func(k string, _ dedupValue) error { dedup = append(dedup, k); return nil }),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) == 0 && len(dedup) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs %v and dedup state %v are left", jobs, dedup)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDedupClaimIsAtomic(t *testing.T) {
+	db, err := New(Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const workers = 16
+	var claimed atomic.Int32
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := db.queueDedup.Update("fetch/owner/repo", func(v dedupValue, found bool) (dedupValue, bool, error) {
+				if found && v.Running {
+					return v, false, nil
+				}
+				claimed.Add(1)
+				return dedupValue{Posted: time.Now(), Running: true}, true, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := claimed.Load(); n != 1 {
+		t.Errorf("claimed %d times, want once", n)
+	}
+	v, ok, err := db.queueDedup.Get("fetch/owner/repo")
+	if err != nil || !ok || !v.Running {
+		t.Errorf("dedup state = %+v, %v, %v, want running", v, ok, err)
+	}
+}
+
+func TestParseRetryOverride(t *testing.T) {
+	base := RetryPolicy{MaxAttempts: 5, Backoff: 10 * time.Second, MaxBackoff: 10 * time.Minute, Jitter: 0.2}
+	tests := []struct {
+		in     string
+		queue  string
+		policy RetryPolicy
+		err    bool
+	}{
+		{in: "fetchVersionFromReleases=3/30s/1h", queue: "fetchVersionFromReleases", policy: RetryPolicy{MaxAttempts: 3, Backoff: 30 * time.Second, MaxBackoff: time.Hour, Jitter: 0.2}},
+		{in: "fetchRepoFromBranches=10", queue: "fetchRepoFromBranches", policy: RetryPolicy{MaxAttempts: 10, Backoff: 10 * time.Second, MaxBackoff: 10 * time.Minute, Jitter: 0.2}},
+		{in: "fetchRepoFromBranches=/1m", queue: "fetchRepoFromBranches", policy: RetryPolicy{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: 10 * time.Minute, Jitter: 0.2}},
+		{in: "fetchRepoFromBranches=1//0s", queue: "fetchRepoFromBranches", policy: RetryPolicy{MaxAttempts: 1, Backoff: 10 * time.Second, Jitter: 0.2}},
+		{in: "fetchRepoFromBranches", err: true},
+		{in: "=3", err: true},
+		{in: "fetchRepoFromBranches=0", err: true},
+		{in: "fetchRepoFromBranches=x", err: true},
+		{in: "fetchRepoFromBranches=3/soon", err: true},
+		{in: "fetchRepoFromBranches=3/1s/1m/1h", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			queue, policy, err := parseRetryOverride(tt.in, base)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if queue != tt.queue || policy != tt.policy {
+				t.Errorf("got %s %+v, want %s %+v", queue, policy, tt.queue, tt.policy)
+			}
+		})
+	}
+}
+
+func TestNewLocked(t *testing.T) {
+	params := Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")}
+	db, err := New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	timeout := sharedbbolt.OpenTimeout
+	sharedbbolt.OpenTimeout = 10 * time.Millisecond
+	defer func() { sharedbbolt.OpenTimeout = timeout }()
+	if _, err := New(params); !errors.Is(err, ErrLocked) {
+		t.Errorf("err = %v, want ErrLocked", err)
+	}
+}
@@ -2,8 +2,10 @@ package sharedbbolt
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 
@@ -64,6 +66,72 @@ func (s *SharedState) Delete(bucketName, key []byte) error {
 	})
 }
 
+// DeleteKeys deletes all keys in one transaction
+func (s *SharedState) DeleteKeys(bucketName []byte, keys [][]byte) error {
+	db := s.p.Load()
+	if db == nil {
+		return errors.New("db is not initialized")
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Update reads and writes the value of key in one transaction, fn gets nil for a missing key
+// and returns the new value, nil keeps the stored value
+func (s *SharedState) Update(bucketName, key []byte, fn func(value []byte) ([]byte, error)) error {
+	db := s.p.Load()
+	if db == nil {
+		return errors.New("db is not initialized")
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		value, err := fn(b.Get(key))
+		if err != nil || value == nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+func (s *SharedState) ForEach(bucketName []byte, fn func(k, v []byte) error) error {
+	db := s.p.Load()
+	if db == nil {
+		return errors.New("db is not initialized")
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		return b.ForEach(fn)
+	})
+}
+
+// ForEachKey calls fn for every key of the bucket with the size of its value, the values are not read
+func (s *SharedState) ForEachKey(bucketName []byte, fn func(k []byte, size int) error) error {
+	db := s.p.Load()
+	if db == nil {
+		return errors.New("db is not initialized")
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			// nested buckets have no value
+			if v == nil {
+				continue
+			}
+			if err := fn(k, len(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *SharedState) Close(bucketName string) error {
 	s.pl.Lock()
 	defer s.pl.Unlock()
@@ -148,6 +216,55 @@ func (s *Store) Delete(k string) error {
 	return s.db.Delete(s.bucketName, []byte(k))
 }
 
+// DeleteAll deletes the stored values for all given keys at once.
+func (s *Store) DeleteAll(keys []string) error {
+	bkeys := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		if err := util.CheckKey(k); err != nil {
+			return err
+		}
+		bkeys = append(bkeys, []byte(k))
+	}
+	return s.db.DeleteKeys(s.bucketName, bkeys)
+}
+
+// Update reads and changes the stored value for the given key in one transaction.
+// decode unmarshals the current value into v, found is false for a missing key.
+// fn returns the new value, a nil value keeps the stored one.
+func (s *Store) Update(k string, fn func(found bool, decode func(v any) error) (any, error)) error {
+	if err := util.CheckKey(k); err != nil {
+		return err
+	}
+	return s.db.Update(s.bucketName, []byte(k), func(data []byte) ([]byte, error) {
+		v, err := fn(data != nil, func(v any) error {
+			return s.codec.Unmarshal(data, v)
+		})
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return s.codec.Marshal(v)
+	})
+}
+
+// ForEach calls fn for every key-value pair in the store.
+// decode unmarshals the value of the current key into v, it is only valid during the call.
+// fn must not modify the database.
+func (s *Store) ForEach(fn func(k string, decode func(v any) error) error) error {
+	return s.db.ForEach(s.bucketName, func(k, data []byte) error {
+		return fn(string(k), func(v any) error {
+			return s.codec.Unmarshal(data, v)
+		})
+	})
+}
+
+// ForEachKey calls fn for every key in the store with the size of the encoded value without reading it.
+// fn must not modify the database.
+func (s *Store) ForEachKey(fn func(k string, size int) error) error {
+	return s.db.ForEachKey(s.bucketName, func(k []byte, size int) error {
+		return fn(string(k), size)
+	})
+}
+
 // Close closes the store.
 // It must be called to make sure that all open transactions finish and to release all DB resources.
 func (s *Store) Close() error {
@@ -176,6 +293,10 @@ var DefaultOptions = Options{
 // Optional ("bbolt.db" by default).
 var DefaultPath = "bbolt.db"
 
+// OpenTimeout is how long to wait for the lock on the DB file,
+// e.g. when another pages-server process is using the same database.
+var OpenTimeout = 5 * time.Second
+
 func NewSharedState(path string) (*SharedState, error) {
 	ret := &SharedState{
 		buckets: make(map[string]struct{}),
@@ -183,9 +304,9 @@ func NewSharedState(path string) (*SharedState, error) {
 	if path == "" {
 		path = DefaultPath
 	}
-	db, err := bolt.Open(path, 0o600, nil)
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: OpenTimeout})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
 	}
 	ret.p.Store(db)
 	return ret, nil
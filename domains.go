@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// domainCtxKey keeps the repository served on a custom domain
+type domainCtxKey struct{}
+
+func domainRepoFromContext(ctx context.Context) (types.Repo, bool) {
+	repo, ok := ctx.Value(domainCtxKey{}).(types.Repo)
+	return repo, ok
+}
+
+// hostName returns the lowercase host of a Host header without port
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+var domainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeDomain validates a domain name and converts it to lowercase
+func normalizeDomain(s string) (string, error) {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+	if domain == "" || len(domain) > 253 {
+		return "", fmt.Errorf("invalid domain %q", s)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if !domainLabel.MatchString(label) {
+			return "", fmt.Errorf("invalid domain %q", s)
+		}
+	}
+	return domain, nil
+}
+
+// parseCNAME returns the domain from the first non-empty line of a CNAME file
+func parseCNAME(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		domain, err := normalizeDomain(line)
+		if err != nil {
+			slog.Warn("ignoring CNAME file", "err", err)
+			return ""
+		}
+		return domain
+	}
+	return ""
+}
+
+// domainVerificationPrefix is the label of the TXT record which names the repository allowed to claim a domain
+const domainVerificationPrefix = "_pages-server."
+
+// lookupTXT is replaced in tests
+var lookupTXT = net.LookupTXT
+
+// verifyDomain tells if the TXT record _pages-server.{domain} names the repository as owner/repo
+func verifyDomain(domain string, repo types.Repo) bool {
+	records, err := lookupTXT(domainVerificationPrefix + domain)
+	if err != nil {
+		slog.Info("custom domain is not verified", "domain", domain, "repo", repo, "err", err)
+		return false
+	}
+	for _, record := range records {
+		if strings.EqualFold(strings.TrimSpace(record), repo.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncCNAME registers the domain from the CNAME file of the latest version of the repo
+// and drops domains registered by its older CNAME files. A domain is served once the TXT record
+// _pages-server.{domain} names the repository, until then the claim is pending and waits for
+// the record or an administrator. Domains mapped by an administrator or claimed by another
+// repository are left alone, verified claims replace pending claims of other repositories.
+func syncCNAME(db *database.Database, repo types.Repo) error {
+	repoInfo, ok, err := db.RepoPages().Get(repo)
+	if err != nil || !ok {
+		return err
+	}
+	config, ok, err := db.PagesConfig().Get(repoInfo.Latest.SHA)
+	if err != nil || !ok {
+		// the latest version is not fetched yet, it is synced once it is stored
+		return err
+	}
+	var stale []string
+	err = db.CustomDomains().ForEach(func(domain string, cd types.CustomDomain) error {
+		if cd.Repo == repo && cd.Source == types.DomainSourceCname && domain != config.CNAME {
+			stale = append(stale, domain)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list custom domains: %w", err)
+	}
+	if len(stale) != 0 {
+		slog.Info("removing custom domains", "repo", repo, "domains", stale)
+		if err := db.CustomDomains().DeleteAll(stale); err != nil {
+			return fmt.Errorf("failed to remove custom domains: %w", err)
+		}
+	}
+	if config.CNAME == "" {
+		return nil
+	}
+	cd, ok, err := db.CustomDomains().Get(config.CNAME)
+	if err != nil {
+		return err
+	}
+	verified := verifyDomain(config.CNAME, repo)
+	if ok && (!cd.Pending || !verified) {
+		if cd.Repo != repo {
+			slog.Warn("custom domain is already used", "domain", config.CNAME, "repo", repo, "usedBy", cd.Repo, "source", cd.Source)
+		}
+		return nil
+	}
+	slog.Info("registering custom domain", "domain", config.CNAME, "repo", repo, "pending", !verified)
+	return db.CustomDomains().Set(config.CNAME, types.CustomDomain{Repo: repo, Source: types.DomainSourceCname, Pending: !verified})
+}
+
+// servedDomain returns the custom domain served at host, pending domains are not served
+func servedDomain(db *database.Database, host string) (types.CustomDomain, bool, error) {
+	cd, ok, err := db.CustomDomains().Get(host)
+	if err != nil || !ok || cd.Pending {
+		return types.CustomDomain{}, false, err
+	}
+	return cd, true, nil
+}
+
+// isSitesHost tells if sites are served at host apart from the pages server itself
+func isSitesHost(pages PagesInfo, db *database.Database, host string) (bool, error) {
+	if _, ok := subdomainOwner(pages, host); ok {
+		return true, nil
+	}
+	_, ok, err := servedDomain(db, host)
+	return ok, err
+}
+
+// hostRouter passes requests to isolated site origins to isolated, requests to owner subdomains
+// to subdomain with the owner in the context, requests to custom domains to domain with the repository
+// in the context, other requests go to main
+func hostRouter(gi GiteaPagesInfo, db *database.Database, iso *isolation, main, domain, subdomain, isolated http.Handler) http.Handler {
+	mainHost := gi.Pages.Host()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := hostName(r.Host)
+		if host == mainHost {
+			main.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := iso.hostLabel(host); ok {
+			isolated.ServeHTTP(w, r)
+			return
+		}
+		if owner, ok := subdomainOwner(gi.Pages, host); ok {
+			subdomain.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerCtxKey{}, owner)))
+			return
+		}
+		cd, ok, err := servedDomain(db, host)
+		if err != nil {
+			slog.Error("failed to get custom domain", "host", host, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			main.ServeHTTP(w, r)
+			return
+		}
+		domain.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), domainCtxKey{}, cd.Repo)))
+	})
+}
+
+// domainPageRequest parses /[@version/]{path} of a custom domain
+func domainPageRequest(r *http.Request, _ *gitea.Client) (pageRequest, error) {
+	repo, _ := domainRepoFromContext(r.Context())
+	return rootPageRequest(repo, strings.TrimPrefix(r.URL.Path, "/")), nil
+}
+
+// domainsHandler lists custom domains
+func domainsHandler(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		domains := map[string]types.CustomDomain{}
+		err := db.CustomDomains().ForEach(func(domain string, cd types.CustomDomain) error {
+			domains[domain] = cd
+			return nil
+		})
+		if err != nil {
+			slog.Error("failed to list custom domains", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, domains)
+	}
+}
+
+// putDomainHandler maps a custom domain to a repository, the mapping takes precedence over CNAME files
+// and approves pending claims
+func putDomainHandler(gi GiteaPagesInfo, db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, err := normalizeDomain(chi.URLParam(r, "domain"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if domain == gi.Pages.Host() {
+			http.Error(w, "the domain of the pages server can not be mapped", http.StatusBadRequest)
+			return
+		}
+		if _, ok := subdomainOwner(gi.Pages, domain); ok {
+			http.Error(w, "owner subdomains can not be mapped", http.StatusBadRequest)
+			return
+		}
+		var repo types.Repo
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&repo); err != nil {
+			http.Error(w, fmt.Sprintf("invalid repository: %s", err), http.StatusBadRequest)
+			return
+		}
+		if repo.Owner == "" || repo.Repo == "" {
+			http.Error(w, "owner and repo are required", http.StatusBadRequest)
+			return
+		}
+		cd := types.CustomDomain{Repo: repo, Source: types.DomainSourceAdmin}
+		if err := db.CustomDomains().Set(domain, cd); err != nil {
+			slog.Error("failed to set custom domain", "domain", domain, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("custom domain mapped", "domain", domain, "repo", repo)
+		writeJSON(w, http.StatusOK, cd)
+	}
+}
+
+// deleteDomainHandler removes a custom domain
+func deleteDomainHandler(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, err := normalizeDomain(chi.URLParam(r, "domain"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, ok, err := db.CustomDomains().Get(domain)
+		if err == nil && !ok {
+			http.Error(w, "custom domain not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			err = db.CustomDomains().Delete(domain)
+		}
+		if err != nil {
+			slog.Error("failed to delete custom domain", "domain", domain, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("custom domain removed", "domain", domain)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/templates"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+var (
+	errChecksumMismatch   = errors.New("sha256 mismatch")
+	errIncompleteDownload = errors.New("failed to write all data")
+)
+
+// fetchFailureReasons explain error classes on the failure page
+var fetchFailureReasons = map[types.FetchErrorClass]string{
+	types.FetchErrorClassOther:        "The fetch failed.",
+	types.FetchErrorClassNetwork:      "Gitea could not be reached or the download was interrupted.",
+	types.FetchErrorClassNotFound:     "The pages were not found in Gitea, the package, release attachment or branch may have been removed.",
+	types.FetchErrorClassAccessDenied: "The pages server has no access to the repository in Gitea.",
+	types.FetchErrorClassGitea:        "Gitea failed to serve the pages.",
+	types.FetchErrorClassChecksum:     "The downloaded archive does not match its checksum.",
+	types.FetchErrorClassArchive:      "The archive is not a valid zip file.",
+}
+
+// classifyFetchError finds the class of an error returned by a fetch task
+func classifyFetchError(err error) types.FetchErrorClass {
+	var ge *giteaError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errChecksumMismatch):
+		return types.FetchErrorClassChecksum
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrAlgorithm), errors.Is(err, zip.ErrChecksum):
+		return types.FetchErrorClassArchive
+	case errors.As(err, &ge):
+		switch ge.status {
+		case http.StatusNotFound:
+			return types.FetchErrorClassNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return types.FetchErrorClassAccessDenied
+		default:
+			return types.FetchErrorClassGitea
+		}
+	case errors.Is(err, errIncompleteDownload), errors.As(err, &netErr):
+		return types.FetchErrorClassNetwork
+	default:
+		return types.FetchErrorClassOther
+	}
+}
+
+// recordFetchResult stores the failure of an attempt to fetch the version of the repository, or removes
+// the last failure once the fetch succeeds. Interrupted fetches are not failures.
+func recordFetchResult(db *database.Database, key string, attempt database.JobAttempt, err error) {
+	if err == nil {
+		if err := db.FetchFailures().Delete(key); err != nil {
+			slog.Warn("failed to remove fetch failure", "key", key, "err", err)
+		}
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failure, _, gerr := db.FetchFailures().Get(key)
+	if gerr != nil {
+		slog.Warn("failed to get fetch failure", "key", key, "err", gerr)
+	}
+	failure.Class = classifyFetchError(err)
+	failure.Error = err.Error()
+	failure.Attempts = attempt.Attempt
+	failure.Dead = attempt.Final()
+	failure.FailedAt = time.Now()
+	if err := db.FetchFailures().Set(key, failure); err != nil {
+		slog.Warn("failed to store fetch failure", "key", key, "err", err)
+	}
+}
+
+// lastFetchFailure returns the failure of the last attempt to fetch the version of the repository,
+// unless the fetch is running again or a retry was requested
+func lastFetchFailure(db *database.Database, repo types.Repo, versionName string) (types.FetchFailure, bool, error) {
+	key := repoVersionKey(repo, versionName)
+	failure, ok, err := db.FetchFailures().Get(key)
+	if err != nil {
+		return failure, false, fmt.Errorf("failed to get fetch failure %w", err)
+	}
+	if !ok || failure.Retrying() {
+		return failure, false, nil
+	}
+	_, running, err := db.FetchProgress().Get(key)
+	if err != nil {
+		return failure, false, fmt.Errorf("failed to get fetch progress %w", err)
+	}
+	return failure, !running, nil
+}
+
+// activeFetchFailure returns the failure of the fetch of the version of the repository once the fetch
+// job ran out of attempts, failures of attempts which are retried by the queue are not reported
+func activeFetchFailure(db *database.Database, repo types.Repo, versionName string) (types.FetchFailure, bool, error) {
+	failure, failed, err := lastFetchFailure(db, repo, versionName)
+	return failure, failed && failure.Dead, err
+}
+
+// retryFetch requests a new fetch of the site version after a failure and returns to the failed page
+func retryFetch(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, db *database.Database, q *database.Queue, pr pageRequest, rt types.RepoType) {
+	site, _, err := requestSiteVersion(pr.Repo, pr.Version, rt, db, nil)
+	if errors.Is(err, ErrVersionNotFound) {
+		errorPage(gi, http.StatusNotFound, err, w)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get site version", "repo", pr.Repo, "version", pr.Version, "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	key := repoVersionKey(pr.Repo, site.Version.Version)
+	failure, ok, err := db.FetchFailures().Get(key)
+	if err == nil && ok {
+		failure.RetryAt = time.Now()
+		err = db.FetchFailures().Set(key, failure)
+	}
+	if err == nil {
+		if site.Version.Version == "" {
+			err = fetchRepo(pr.Repo, rt, q)
+		} else {
+			err = fetchVersion(pr.Repo, site.Version, rt, q)
+		}
+	}
+	if err != nil {
+		slog.Error("failed to retry fetch", "repo", pr.Repo, "version", site.Version.Version, "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	slog.Info("fetch retry requested", "repo", pr.Repo, "version", site.Version.Version)
+	ret := r.PostFormValue("return")
+	if ret == "" {
+		ret = pr.Base
+	}
+	http.Redirect(w, r, localPath(ret), http.StatusSeeOther)
+}
+
+// fetchFailedPage explains why the page could not be fetched and offers to retry the fetch,
+// the error itself is shown to site administrators only
+func fetchFailedPage(gi GiteaPagesInfo, w http.ResponseWriter, r *http.Request, pr pageRequest, versionName string, failure types.FetchFailure) {
+	// errors name internal URLs of Gitea, only administrators see them
+	var detail string
+	if isSiteAdmin(r) {
+		detail = failure.Error
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusBadGateway)
+	if err := templates.FetchFailed.Execute(w, struct {
+		Info     GiteaPagesInfo
+		Repo     types.Repo
+		Version  string
+		Reason   string
+		Error    string
+		Failure  types.FetchFailure
+		RetryURL string
+		Return   string
+	}{
+		Info:     gi,
+		Repo:     pr.Repo,
+		Version:  versionName,
+		Reason:   fetchFailureReasons[failure.Class],
+		Error:    detail,
+		Failure:  failure,
+		RetryURL: pr.Base + progressFile,
+		Return:   r.URL.RequestURI(),
+	}); err != nil {
+		// the response is already started, the template error can only be logged
+		slog.Error("failed to execute fetch failed template", "err", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestClassifyFetchError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class types.FetchErrorClass
+	}{
+		{name: "checksum", err: fmt.Errorf("failed to verify docs.zip: %w", errChecksumMismatch), class: types.FetchErrorClassChecksum},
+		{name: "not a zip", err: fmt.Errorf("failed to open archive: %w", zip.ErrFormat), class: types.FetchErrorClassArchive},
+		{name: "zip checksum", err: zip.ErrChecksum, class: types.FetchErrorClassArchive},
+		{name: "gitea not found", err: &giteaError{status: http.StatusNotFound, err: errors.New("404 Not Found")}, class: types.FetchErrorClassNotFound},
+		{name: "gitea unauthorized", err: fmt.Errorf("listing: %w", &giteaError{status: http.StatusUnauthorized}), class: types.FetchErrorClassAccessDenied},
+		{name: "gitea forbidden", err: &giteaError{status: http.StatusForbidden}, class: types.FetchErrorClassAccessDenied},
+		{name: "gitea failure", err: &giteaError{status: http.StatusInternalServerError}, class: types.FetchErrorClassGitea},
+		{name: "incomplete download", err: fmt.Errorf("docs.zip: %w", errIncompleteDownload), class: types.FetchErrorClassNetwork},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, class: types.FetchErrorClassNetwork},
+		{name: "other", err: errors.New("something else"), class: types.FetchErrorClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := classifyFetchError(tt.err); class != tt.class {
+				t.Errorf("class = %s, want %s", class, tt.class)
+			}
+		})
+	}
+}
+
+func TestRecordFetchResult(t *testing.T) {
+	db, err := database.New(database.Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := types.Repo{Owner: "owner", Repo: "repo"}
+	key := repoVersionKey(repo, "v1")
+	notFound := &giteaError{status: http.StatusNotFound, err: errors.New("404 Not Found")}
+
+	tests := []struct {
+		name     string
+		attempt  database.JobAttempt
+		err      error
+		recorded bool
+		active   bool
+	}{
+		{name: "retried attempt", attempt: database.JobAttempt{Attempt: 1, MaxAttempts: 3}, err: notFound, recorded: true},
+		{name: "interrupted attempt", attempt: database.JobAttempt{Attempt: 2, MaxAttempts: 3}, err: context.Canceled, recorded: true},
+		{name: "last attempt", attempt: database.JobAttempt{Attempt: 3, MaxAttempts: 3}, err: notFound, recorded: true, active: true},
+		{name: "success", attempt: database.JobAttempt{Attempt: 1, MaxAttempts: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordFetchResult(db, key, tt.attempt, tt.err)
+			_, recorded, err := lastFetchFailure(db, repo, "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			failure, active, err := activeFetchFailure(db, repo, "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorded != tt.recorded || active != tt.active {
+				t.Errorf("recorded, active = %v, %v, want %v, %v", recorded, active, tt.recorded, tt.active)
+			}
+			if active && (failure.Attempts != tt.attempt.Attempt || failure.Class != types.FetchErrorClassNotFound) {
+				t.Errorf("failure = %+v", failure)
+			}
+		})
+	}
+}
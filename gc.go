@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	clive "github.com/ASMfreaK/clive2"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/urfave/cli/v2"
+)
+
+type GCInfo struct {
+	Interval time.Duration `cli:"usage:'how often to remove pages which are no longer referenced by any repository, 0 disables collection',default:'24h'"`
+}
+
+// runGC collects garbage every interval until ctx is done
+func runGC(ctx context.Context, db *database.Database, interval, maxStaleness time.Duration) {
+	if interval <= 0 {
+		slog.Info("garbage collection is disabled")
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if _, err := db.CollectGarbage(false, maxStaleness); err != nil {
+			slog.Error("failed to collect garbage", "err", err)
+		}
+	}
+}
+
+// gcHandler triggers garbage collection, ?dry_run=true only reports what would be removed
+func gcHandler(db *database.Database, maxStaleness time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		report, err := db.CollectGarbage(dryRun, maxStaleness)
+		if err != nil {
+			slog.Error("failed to collect garbage", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+type CollectGarbage struct {
+	*clive.Command `cli:"name:'gc',usage:'remove pages which are no longer referenced by any repository and exit, the server must be stopped'"`
+	DryRun         bool `cli:"usage:'only report what would be removed'"`
+}
+
+func (cmd *CollectGarbage) Action(ctx *cli.Context) error {
+	a := cmd.Root(ctx).(*app)
+	db, err := openStoppedDatabase(a.Database, "POST /_admin/gc")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	report, err := db.CollectGarbage(cmd.DryRun, a.Pages.MaxStaleness)
+	if err != nil {
+		return err
+	}
+	verb := "removed"
+	if cmd.DryRun {
+		verb = "would remove"
+	}
+	fmt.Fprintf(ctx.App.Writer, "%s %d versions and %d blobs, %d bytes (%d live versions, %d live blobs)\n",
+		verb, report.Versions, report.Blobs, report.Bytes, report.LiveVersions, report.LiveBlobs)
+	return nil
+}
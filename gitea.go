@@ -2,8 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
 
 	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/consts"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
 )
 
 func allGiteaPages[T any](ctx context.Context, content func(ctx context.Context, opts gitea.ListOptions) ([]T, *gitea.Response, error)) (ret []T, err error) {
@@ -30,3 +36,64 @@ func allGiteaPages[T any](ctx context.Context, content func(ctx context.Context,
 	}
 	return
 }
+
+// giteaError keeps the status of a failed Gitea API response
+type giteaError struct {
+	status int
+	err    error
+}
+
+func (e *giteaError) Error() string {
+	return e.err.Error()
+}
+
+func (e *giteaError) Unwrap() error {
+	return e.err
+}
+
+func wrapGiteaError(rsp *gitea.Response, err error) error {
+	if err == nil || rsp == nil {
+		return err
+	}
+	return &giteaError{status: rsp.StatusCode, err: err}
+}
+
+// giteaErrorStatus converts a failed Gitea call into the status returned to the client:
+// authentication and access errors are passed through, Gitea failures become 502 or 503
+func giteaErrorStatus(err error) int {
+	var ge *giteaError
+	if !errors.As(err, &ge) {
+		return http.StatusBadGateway
+	}
+	switch ge.status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusServiceUnavailable:
+		return ge.status
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// repoPagesTypes lists pages- topics of the repository and converts them to RepoType
+func repoPagesTypes(ctx context.Context, c *gitea.Client, owner, repoName string) ([]types.RepoType, error) {
+	return allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]types.RepoType, *gitea.Response, error) {
+		topics, rsp, lterr := c.ListRepoTopics(owner, repoName, gitea.ListRepoTopicsOptions{ListOptions: opts})
+		if lterr != nil {
+			return nil, nil, wrapGiteaError(rsp, lterr)
+		}
+		var ret []types.RepoType
+		for _, topic := range topics {
+			name := strings.TrimPrefix(topic, consts.PagesLabelPrefix)
+			if name == topic { // did not have prefix
+				slog.Info("topic does not contain", "label", topic, "prefix", consts.PagesLabelPrefix)
+				continue
+			}
+			rt, perr := types.ParseRepoType(name)
+			if perr != nil {
+				slog.Error("Failed to parse pages- topic part", "part", name)
+				continue
+			}
+			ret = append(ret, rt)
+		}
+		return ret, rsp, nil
+	})
+}
@@ -5,14 +5,15 @@ go 1.22.5
 require (
 	code.gitea.io/sdk/gitea v0.19.0
 	github.com/ASMfreaK/clive2 v0.5.1
+	github.com/andybalholm/brotli v1.2.6
 	github.com/bitfield/script v0.22.1
 	github.com/cirruslabs/echelon v1.9.0
 	github.com/fatih/color v1.17.0
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/go-chi/httplog/v2 v2.1.1
 	github.com/go-chi/jwtauth/v5 v5.3.1
-	github.com/golang-queue/queue v0.2.0
 	github.com/hashicorp/go-multierror v1.1.1
+	github.com/hashicorp/go-version v1.6.0
 	github.com/lestrrat-go/jwx/v2 v2.0.20
 	github.com/oklog/ulid/v2 v2.1.0
 	github.com/philippgille/gokv v0.7.0
@@ -46,9 +47,7 @@ require (
 	github.com/go-fed/httpsig v1.1.0 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
-	github.com/hashicorp/go-version v1.6.0 // indirect
 	github.com/iancoleman/strcase v0.3.0 // indirect
-	github.com/jpillora/backoff v1.0.0 // indirect
 	github.com/lestrrat-go/blackmagic v1.0.2 // indirect
 	github.com/lestrrat-go/httpcc v1.0.1 // indirect
 	github.com/lestrrat-go/httprc v1.0.4 // indirect
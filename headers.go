@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+// deniedHeaders are managed by the server or critical for security, sites may not set them
+var deniedHeaders = []string{
+	"Set-Cookie",
+	"Set-Cookie2",
+	"Clear-Site-Data",
+	"WWW-Authenticate",
+	"Location",
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Service-Worker-Allowed",
+	"Strict-Transport-Security",
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Content-Range",
+	"Transfer-Encoding",
+	"Connection",
+	"Accept-Ranges",
+	"ETag",
+	"Last-Modified",
+	"Vary",
+}
+
+// parseHeaders parses a Netlify-style _headers file:
+//
+//	/path/*
+//	  Header-Name: value
+//
+// Paths are matched like in _redirects, all matching rules apply.
+func parseHeaders(data []byte) []types.HeaderRule {
+	var rules []types.HeaderRule
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "/") {
+			rules = append(rules, types.HeaderRule{Path: text, Headers: make(map[string][]string)})
+			continue
+		}
+		name, value, ok := strings.Cut(text, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") || len(rules) == 0 {
+			slog.Warn("skipping malformed header rule", "line", line, "rule", text)
+			continue
+		}
+		name = http.CanonicalHeaderKey(name)
+		headers := rules[len(rules)-1].Headers
+		headers[name] = append(headers[name], value)
+	}
+	return rules
+}
+
+// applyHeaders adds headers of all rules matching the site path, except the denied ones
+func applyHeaders(h http.Header, rules []types.HeaderRule, sitePath string, denied []string) {
+	for _, rule := range rules {
+		if _, ok := matchPath(rule.Path, sitePath); !ok {
+			continue
+		}
+		for name, values := range rule.Headers {
+			if isDeniedHeader(name, denied) {
+				slog.Warn("site tried to set a denied header", "header", name, "path", rule.Path)
+				continue
+			}
+			for _, v := range values {
+				h.Add(name, v)
+			}
+		}
+	}
+}
+
+func isDeniedHeader(name string, denied []string) bool {
+	for _, list := range [][]string{deniedHeaders, denied} {
+		for _, d := range list {
+			if strings.EqualFold(d, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
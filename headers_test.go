@@ -0,0 +1,114 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		rules []types.HeaderRule
+	}{
+		{
+			name: "single rule",
+			in:   "/*\n  X-Frame-Options: DENY\n",
+			rules: []types.HeaderRule{
+				{Path: "/*", Headers: map[string][]string{"X-Frame-Options": {"DENY"}}},
+			},
+		},
+		{
+			name: "several rules and values",
+			in: `# assets are immutable
+/assets/*
+  Cache-Control: public, max-age=31536000, immutable
+  link: </assets/app.css>; rel=preload; as=style
+  Link: </assets/app.js>; rel=preload; as=script
+
+/
+  X-Robots-Tag: noindex
+`,
+			rules: []types.HeaderRule{
+				{Path: "/assets/*", Headers: map[string][]string{
+					"Cache-Control": {"public, max-age=31536000, immutable"},
+					"Link":          {"</assets/app.css>; rel=preload; as=style", "</assets/app.js>; rel=preload; as=script"},
+				}},
+				{Path: "/", Headers: map[string][]string{"X-Robots-Tag": {"noindex"}}},
+			},
+		},
+		{
+			name: "empty value",
+			in:   "/\n  X-Empty:\n",
+			rules: []types.HeaderRule{
+				{Path: "/", Headers: map[string][]string{"X-Empty": {""}}},
+			},
+		},
+		{
+			name: "malformed lines",
+			in:   "X-Before-Path: 1\n/\n  no colon\n  : no name\n  Bad Name: x\n  X-Ok: 1\n",
+			rules: []types.HeaderRule{
+				{Path: "/", Headers: map[string][]string{"X-Ok": {"1"}}},
+			},
+		},
+		{
+			name:  "path without headers",
+			in:    "/empty\n",
+			rules: []types.HeaderRule{{Path: "/empty", Headers: map[string][]string{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseHeaders([]byte(tt.in))
+			if !slices.EqualFunc(rules, tt.rules, func(a, b types.HeaderRule) bool {
+				return a.Path == b.Path && maps.EqualFunc(a.Headers, b.Headers, slices.Equal)
+			}) {
+				t.Errorf("rules = %+v, want %+v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestApplyHeaders(t *testing.T) {
+	rules := parseHeaders([]byte(`
+/*
+  X-Frame-Options: DENY
+  Set-Cookie: session=stolen
+  Service-Worker-Allowed: /
+  Strict-Transport-Security: max-age=31536000; includeSubDomains
+  Access-Control-Allow-Origin: *
+/docs/*
+  Cache-Control: no-cache
+  X-Custom: docs
+`))
+	tests := []struct {
+		name   string
+		path   string
+		denied []string
+		want   http.Header
+	}{
+		{name: "root", path: "/", want: http.Header{"X-Frame-Options": {"DENY"}}},
+		{name: "all matching rules", path: "/docs/index.html", want: http.Header{
+			"X-Frame-Options": {"DENY"},
+			"Cache-Control":   {"no-cache"},
+			"X-Custom":        {"docs"},
+		}},
+		{name: "configured denied headers", path: "/docs/", denied: []string{"x-custom"}, want: http.Header{
+			"X-Frame-Options": {"DENY"},
+			"Cache-Control":   {"no-cache"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			applyHeaders(h, rules, tt.path, tt.denied)
+			if !maps.EqualFunc(h, tt.want, slices.Equal) {
+				t.Errorf("headers = %v, want %v", h, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/consts"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxHookPayloadSize limits the size of the webhook body we are willing to read.
+const maxHookPayloadSize = 25 << 20
+
+// verifiedGiteaWebhook checks X-Gitea-Signature (HMAC-SHA256 of the raw body)
+// against every configured secret, so secrets can be rotated without downtime.
+// Deliveries without a valid signature are rejected with 401.
+func verifiedGiteaWebhook(secrets []string) func(next http.Handler) http.Handler {
+	var keys [][]byte
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		keys = append(keys, []byte(secret))
+	}
+	if len(keys) == 0 {
+		slog.Warn("no gitea hook secret is configured, all webhook deliveries will be rejected")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := slog.With(
+				slog.String("remote", r.RemoteAddr),
+				slog.String("path", r.URL.Path),
+				slog.String("event", r.Header.Get(consts.GiteaEventHeader)),
+				slog.String("delivery", r.Header.Get(consts.GiteaDeliveryHeader)),
+			)
+			signature := r.Header.Get(consts.GiteaSignatureHeader)
+			if signature == "" {
+				log.Warn("rejected unsigned gitea webhook")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			mac, err := hex.DecodeString(signature)
+			if err != nil {
+				log.Warn("rejected gitea webhook with malformed signature", "err", err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookPayloadSize))
+			if err != nil {
+				log.Error("failed to read gitea webhook body", "err", err)
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if !validHookSignature(keys, body, mac) {
+				log.Warn("rejected gitea webhook with mismatched signature", slog.Int("size", len(body)))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func validHookSignature(keys [][]byte, body, mac []byte) bool {
+	for _, key := range keys {
+		h := hmac.New(sha256.New, key)
+		h.Write(body)
+		if hmac.Equal(h.Sum(nil), mac) {
+			return true
+		}
+	}
+	return false
+}
+
+type hookUser struct {
+	Login string `json:"login"`
+}
+
+type hookRepository struct {
+	Name  string   `json:"name"`
+	Owner hookUser `json:"owner"`
+}
+
+// giteaHookPayload is a union of the fields of push, create, delete, release
+// and package payloads that are needed to decide what has to be refetched.
+type giteaHookPayload struct {
+	Ref        string          `json:"ref"`
+	RefType    string          `json:"ref_type"`
+	Action     string          `json:"action"`
+	Repository *hookRepository `json:"repository"`
+	Package    *struct {
+		Type  string   `json:"type"`
+		Name  string   `json:"name"`
+		Owner hookUser `json:"owner"`
+	} `json:"package"`
+}
+
+func isPagesBranch(name string) bool {
+	return name == consts.PagesBranch || strings.HasPrefix(name, consts.PagesBranchPrefix)
+}
+
+// hookTarget finds the repository and the pages mode affected by the event.
+// ok is false when the event can not change any pages.
+func hookTarget(event string, p *giteaHookPayload) (repo types.Repo, rt types.RepoType, ok bool) {
+	if p.Repository != nil {
+		repo = types.Repo{Owner: p.Repository.Owner.Login, Repo: p.Repository.Name}
+	}
+	switch event {
+	case "push":
+		branch, isBranch := strings.CutPrefix(p.Ref, "refs/heads/")
+		if !isBranch || !isPagesBranch(branch) {
+			return repo, rt, false
+		}
+		rt = types.RepoTypeBranch
+	case "create", "delete":
+		if p.RefType != "branch" || !isPagesBranch(strings.TrimPrefix(p.Ref, "refs/heads/")) {
+			return repo, rt, false
+		}
+		rt = types.RepoTypeBranch
+	case "release":
+		rt = types.RepoTypeRelease
+	case "package":
+		if p.Package == nil || p.Package.Type != "generic" {
+			return repo, rt, false
+		}
+		// generic package named after the repository holds the pages
+		repo = types.Repo{Owner: p.Package.Owner.Login, Repo: p.Package.Name}
+		rt = types.RepoTypePackage
+	default:
+		return repo, rt, false
+	}
+	return repo, rt, repo.Owner != "" && repo.Repo != ""
+}
+
+// giteaWebhook enqueues a refetch of the repository affected by the delivered event.
+// Events are checked against pages- topics of the repository, so e.g. a release
+// in a pages-package repository is ignored.
+func giteaWebhook(c *gitea.Client, q *database.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := r.Header.Get(consts.GiteaEventHeader)
+		log := slog.With(
+			slog.String("event", event),
+			slog.String("delivery", r.Header.Get(consts.GiteaDeliveryHeader)),
+		)
+		var payload giteaHookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			log.Error("failed to parse gitea webhook payload", "err", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if payload.Repository == nil && chi.URLParam(r, "owner") != "" {
+			payload.Repository = &hookRepository{
+				Name:  chi.URLParam(r, "repo"),
+				Owner: hookUser{Login: chi.URLParam(r, "owner")},
+			}
+		}
+		repo, rt, ok := hookTarget(event, &payload)
+		if !ok {
+			log.Info("ignoring gitea webhook", "ref", payload.Ref, "action", payload.Action)
+			_, _ = w.Write([]byte("ignored"))
+			return
+		}
+		log = log.With(slog.String("repo", repo.String()), slog.String("type", rt.String()))
+		repotypes, err := repoPagesTypes(r.Context(), c, repo.Owner, repo.Repo)
+		if err != nil {
+			log.Error("failed to get repo type", "err", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if !slices.Contains(repotypes, rt) {
+			log.Info("ignoring gitea webhook, repo is not configured for this kind of pages", "types", repotypes)
+			_, _ = w.Write([]byte("ignored"))
+			return
+		}
+		err = fetchRepo(repo, rt, q)
+		if err != nil {
+			log.Error("failed to enqueue fetch repo", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info("enqueued fetch repo from gitea webhook")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+const (
+	// siteRepo and siteType are claims of site origin tokens, siteHandoff marks the short-lived
+	// token which is exchanged for the access cookie
+	siteRepo    = "site_repo"
+	siteType    = "site_type"
+	siteHandoff = "site_handoff"
+	// siteLabelLength is the length of the subdomain label of a site origin, 160 bits of the hash
+	siteLabelLength = 32
+)
+
+type IsolationInfo struct {
+	ContentDomain string        `cli:"usage:'serve every site from its own origin, a hashed subdomain of the domain, empty disables isolation'"`
+	CookieTTL     time.Duration `cli:"usage:'how long the access cookie of a site origin is valid',default:'10m'"`
+}
+
+// isolation serves sites from per-repository origins, the pages server checks the access
+// and hands over a repository-scoped access cookie to the origin of the site
+type isolation struct {
+	info       IsolationInfo
+	pages      PagesInfo
+	secret     []byte
+	cookieName string
+	tokenAuth  *jwtauth.JWTAuth
+}
+
+// newIsolation returns nil if isolation is disabled
+func newIsolation(info IsolationInfo, pages PagesInfo, auth *AuthInfo) *isolation {
+	if info.ContentDomain == "" {
+		return nil
+	}
+	return &isolation{
+		info:       info,
+		pages:      pages,
+		secret:     []byte(auth.Secret),
+		cookieName: auth.CookieName,
+		tokenAuth:  auth.State.tokenAuth,
+	}
+}
+
+// label returns the subdomain label of the site origin, it is keyed with the auth secret
+// so the origins of repositories can not be guessed
+func (iso *isolation) label(repo types.Repo) string {
+	mac := hmac.New(sha256.New, iso.secret)
+	mac.Write([]byte(repo.String()))
+	label := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(mac.Sum(nil))
+	return strings.ToLower(label[:siteLabelLength])
+}
+
+// hostLabel returns the label of a host name under the content domain
+func (iso *isolation) hostLabel(host string) (string, bool) {
+	if iso == nil {
+		return "", false
+	}
+	label, ok := strings.CutSuffix(host, "."+strings.ToLower(iso.info.ContentDomain))
+	if !ok || len(label) != siteLabelLength || strings.Contains(label, ".") {
+		return "", false
+	}
+	return label, true
+}
+
+func (iso *isolation) scheme() string {
+	if iso.pages.Secure() {
+		return "https"
+	}
+	return "http"
+}
+
+// sitePath is the path of the page on the site origin
+func sitePath(pr pageRequest, rawQuery string) string {
+	u := url.URL{Path: "/" + pr.Path, RawQuery: rawQuery}
+	if pr.Version != "" {
+		u.Path = "/@" + pr.Version + u.Path
+	}
+	return u.String()
+}
+
+// redirect sends the user, whose access to the repository is checked, to the origin of the site
+// with a handoff token for the access cookie
+func (iso *isolation) redirect(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, db *database.Database, pr pageRequest, rt types.RepoType) {
+	label := iso.label(pr.Repo)
+	if _, ok, err := db.SiteOrigins().Get(label); err != nil || !ok {
+		if err == nil {
+			err = db.SiteOrigins().Set(label, pr.Repo)
+		}
+		if err != nil {
+			slog.Error("failed to register site origin", "repo", pr.Repo, "err", err)
+			errorPage(gi, http.StatusInternalServerError, err, w)
+			return
+		}
+	}
+	claims := map[string]any{
+		siteRepo:    pr.Repo.String(),
+		siteType:    rt.String(),
+		siteHandoff: true,
+	}
+	jwtauth.SetExpiryIn(claims, handoffTTL)
+	_, token, err := iso.tokenAuth.Encode(claims)
+	if err != nil {
+		slog.Error("failed to encode site handoff token", "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	accept := url.URL{
+		Scheme:   iso.scheme(),
+		Host:     label + "." + iso.info.ContentDomain,
+		Path:     "/_auth/site",
+		RawQuery: url.Values{"token": {token}, "return": {sitePath(pr, r.URL.RawQuery)}}.Encode(),
+	}
+	http.Redirect(w, r, accept.String(), http.StatusTemporaryRedirect)
+}
+
+// verify checks a site origin token for the repository of the origin
+func (iso *isolation) verify(token string, repo types.Repo, handoff bool) (types.RepoType, error) {
+	t, err := jwtauth.VerifyToken(iso.tokenAuth, token)
+	if err != nil {
+		return 0, err
+	}
+	claims := t.PrivateClaims()
+	if claimed, _ := claims[siteRepo].(string); claimed != repo.String() {
+		return 0, fmt.Errorf("token is for repo %q", claimed)
+	}
+	if isHandoff, _ := claims[siteHandoff].(bool); isHandoff != handoff {
+		return 0, fmt.Errorf("unexpected token kind")
+	}
+	rt, _ := claims[siteType].(string)
+	return types.ParseRepoType(rt)
+}
+
+// handler serves sites on their origins, requests without a valid access cookie are sent
+// to the pages server which checks the access again
+func (iso *isolation) handler(gi GiteaPagesInfo, db *database.Database, q *database.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		label, _ := iso.hostLabel(hostName(r.Host))
+		repo, ok, err := db.SiteOrigins().Get(label)
+		if err != nil {
+			slog.Error("failed to get site origin", "label", label, "err", err)
+			errorPage(gi, http.StatusInternalServerError, err, w)
+			return
+		}
+		if !ok || iso.label(repo) != label {
+			errorPage(gi, http.StatusNotFound, fmt.Errorf("unknown site origin %s", label), w)
+			return
+		}
+
+		if r.URL.Path == "/_auth/site" {
+			iso.accept(w, r, gi, repo)
+			return
+		}
+
+		pr := rootPageRequest(repo, strings.TrimPrefix(r.URL.Path, "/"))
+		if pr.Path == "" && !strings.HasSuffix(r.URL.Path, "/") {
+			target := url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+			return
+		}
+		var rt types.RepoType
+		cookie, err := r.Cookie(iso.cookieName)
+		if err == nil {
+			rt, err = iso.verify(cookie.Value, repo, false)
+		}
+		if err != nil {
+			slog.Info("site access cookie is missing or invalid", "repo", repo, "err", err)
+			segment := repo.Repo
+			if pr.Version != "" {
+				segment += "@" + pr.Version
+			}
+			check := url.URL{Path: "/" + repo.Owner + "/" + segment + "/" + pr.Path, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, strings.TrimSuffix(iso.pages.URL, "/")+check.String(), http.StatusTemporaryRedirect)
+			return
+		}
+		serveRepoSite(w, r, gi, db, q, pr, rt)
+	}
+}
+
+// accept exchanges a handoff token for the access cookie of the site origin
+func (iso *isolation) accept(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, repo types.Repo) {
+	rt, err := iso.verify(r.URL.Query().Get("token"), repo, true)
+	if err != nil {
+		slog.Error("failed to verify site handoff token", "repo", repo, "err", err)
+		errorPage(gi, http.StatusBadRequest, fmt.Errorf("invalid handoff token"), w)
+		return
+	}
+	claims := map[string]any{
+		siteRepo: repo.String(),
+		siteType: rt.String(),
+	}
+	jwtauth.SetExpiryIn(claims, iso.info.CookieTTL)
+	_, cookie, err := iso.tokenAuth.Encode(claims)
+	if err != nil {
+		slog.Error("failed to encode site access cookie", "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     iso.cookieName,
+		Value:    cookie,
+		MaxAge:   int(iso.info.CookieTTL.Seconds()),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   iso.pages.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, localPath(r.URL.Query().Get("return")), http.StatusTemporaryRedirect)
+}
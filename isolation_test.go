@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+func testIsolation(secret string) *isolation {
+	return &isolation{
+		info:      IsolationInfo{ContentDomain: "Sites.Example.com"},
+		secret:    []byte(secret),
+		tokenAuth: jwtauth.New("HS256", []byte(secret), nil),
+	}
+}
+
+func TestIsolationLabel(t *testing.T) {
+	iso := testIsolation("secret")
+	repo := types.Repo{Owner: "owner", Repo: "repo"}
+	label := iso.label(repo)
+	if len(label) != siteLabelLength || !domainLabel.MatchString(label) {
+		t.Fatalf("label %q is not a %d characters long host label", label, siteLabelLength)
+	}
+
+	tests := []struct {
+		name  string
+		iso   *isolation
+		repo  types.Repo
+		equal bool
+	}{
+		{name: "same repo and secret", iso: testIsolation("secret"), repo: repo, equal: true},
+		{name: "other secret", iso: testIsolation("other secret"), repo: repo},
+		{name: "other repo", iso: iso, repo: types.Repo{Owner: "owner", Repo: "repo2"}},
+		{name: "other owner", iso: iso, repo: types.Repo{Owner: "owner2", Repo: "repo"}},
+		{name: "owner and repo are not concatenated", iso: iso, repo: types.Repo{Owner: "own", Repo: "errepo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.iso.label(tt.repo); (got == label) != tt.equal {
+				t.Errorf("label = %s, label of %s = %s, want equal %v", got, repo, label, tt.equal)
+			}
+		})
+	}
+}
+
+func TestIsolationHostLabel(t *testing.T) {
+	iso := testIsolation("secret")
+	label := iso.label(types.Repo{Owner: "owner", Repo: "repo"})
+	tests := []struct {
+		name string
+		iso  *isolation
+		host string
+		want string
+	}{
+		{name: "site origin", iso: iso, host: label + ".sites.example.com", want: label},
+		{name: "disabled", host: label + ".sites.example.com"},
+		{name: "content domain", iso: iso, host: "sites.example.com"},
+		{name: "other domain", iso: iso, host: label + ".example.com"},
+		{name: "short label", iso: iso, host: label[1:] + ".sites.example.com"},
+		{name: "nested label", iso: iso, host: "a." + label + ".sites.example.com"},
+		{name: "dotted label", iso: iso, host: label[:16] + "." + label[17:] + ".sites.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.iso.hostLabel(tt.host)
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("hostLabel = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsolationVerify(t *testing.T) {
+	iso := testIsolation("secret")
+	repo := types.Repo{Owner: "owner", Repo: "repo"}
+	token := func(iso *isolation, repo types.Repo, handoff bool, ttl time.Duration) string {
+		claims := map[string]any{
+			siteRepo: repo.String(),
+			siteType: types.RepoTypeRelease.String(),
+		}
+		if handoff {
+			claims[siteHandoff] = true
+		}
+		jwtauth.SetExpiryIn(claims, ttl)
+		_, s, err := iso.tokenAuth.Encode(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	tests := []struct {
+		name    string
+		token   string
+		handoff bool
+		ok      bool
+	}{
+		{name: "cookie", token: token(iso, repo, false, time.Minute), ok: true},
+		{name: "handoff", token: token(iso, repo, true, time.Minute), handoff: true, ok: true},
+		{name: "handoff as cookie", token: token(iso, repo, true, time.Minute)},
+		{name: "cookie as handoff", token: token(iso, repo, false, time.Minute), handoff: true},
+		{name: "other repo", token: token(iso, types.Repo{Owner: "owner", Repo: "other"}, false, time.Minute)},
+		{name: "other secret", token: token(testIsolation("other secret"), repo, false, time.Minute)},
+		{name: "expired", token: token(iso, repo, false, -time.Minute)},
+		{name: "garbage", token: "not a token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := iso.verify(tt.token, repo, tt.handoff)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && rt != types.RepoTypeRelease {
+				t.Errorf("repo type = %s, want %s", rt, types.RepoTypeRelease)
+			}
+		})
+	}
+}
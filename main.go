@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
 	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"code.gitea.io/sdk/gitea"
@@ -34,7 +36,7 @@ type GiteaInfo struct {
 	URL        string `cli:"usage:'url for Gitea',default:'http://localhost:3000'"`
 	AdminToken string `cli:"usage:'admin token for Gitea'"`
 
-	HookSecret string `cli:"usage:'secret for gitea webhooks'"`
+	HookSecret []string `cli:"usage:'secrets for gitea webhooks, several secrets are accepted to allow rotation'"`
 
 	PagesAddrFromGitea string `cli:"usage:'url for pages server as viewed from gitea',default:'http://localhost:8000'"`
 }
@@ -46,6 +48,30 @@ func (g *GiteaInfo) HookURL() string {
 type PagesInfo struct {
 	URL   string `cli:"usage:'url for pages server',default:'http://localhost:8000'"`
 	Title string `cli:"usage:'title for pages server',default:'Gitea Pages'"`
+
+	DeniedHeaders []string `cli:"usage:'response headers sites may not set in _headers in addition to the built-in ones'"`
+
+	WildcardDomain string `cli:"usage:'serve owner/repo at owner.WILDCARD-DOMAIN/repo, empty disables owner subdomains'"`
+	RootRepo       string `cli:"usage:'repository served at the root of owner subdomains, {owner} is replaced with the owner',default:'{owner}.pages'"`
+
+	AliasMode string `cli:"usage:'how version aliases like @stable are served: redirect to the version or rewrite to serve it at the alias',default:'redirect'"`
+
+	MaxStaleness time.Duration `cli:"usage:'serve the last served version of a site for at most this long while a newer one is fetched, 0 disables',default:'1h'"`
+	StaleHeader  string        `cli:"usage:'response header set to refreshing on stale responses, empty disables'"`
+}
+
+// Host returns the host name of the pages server without port
+func (p PagesInfo) Host() string {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return ""
+	}
+	return hostName(u.Host)
+}
+
+// Secure tells if the pages server is served over https, cookies are marked secure then
+func (p PagesInfo) Secure() bool {
+	return strings.HasPrefix(p.URL, "https://")
 }
 
 type GiteaPagesInfo struct {
@@ -62,21 +88,45 @@ type app struct {
 
 	Database database.Params `cli:"inline"`
 
+	Queue database.QueueParams `cli:"inline"`
+
 	Auth AuthInfo `cli:"inline"`
 
+	Isolation IsolationInfo `cli:"inline"`
+
+	Webhooks WebhooksInfo `cli:"inline"`
+
+	GC GCInfo `cli:"inline"`
+
+	Retention RetentionInfo `cli:"inline"`
+
+	Ordering OrderingInfo `cli:"inline"`
+
 	Server struct {
-		Addr string `cli:"usage:'address to listen on',default:'localhost:8000'"`
+		Addr            string        `cli:"usage:'address to listen on',default:'localhost:8000'"`
+		ShutdownTimeout time.Duration `cli:"usage:'how long to wait for in-flight requests and running jobs on shutdown',default:'30s'"`
 	} `cli:"inline"`
+
+	Subcommands struct {
+		*ReconcileWebhooks
+		*DeadJobs
+		*RequeueDeadJobs
+		*CollectGarbage
+	}
 }
 
 func (a *app) Version() string {
 	return version
 }
 
-func (a *app) Action(ctx *cli.Context) error {
+func setupLogging() {
 	slog.SetDefault(slog.New(httplog.NewPrettyHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: false,
 	})))
+}
+
+func (a *app) Action(ctx *cli.Context) error {
+	setupLogging()
 	logger := httplog.NewLogger("httplog-example", httplog.Options{
 		// JSON:             true,
 		LogLevel:         slog.LevelDebug,
@@ -97,11 +147,20 @@ func (a *app) Action(ctx *cli.Context) error {
 	jwt.RegisterCustomField(consts.ThisIsAGiteaWebhook, true)
 	jwt.RegisterCustomField(a.Auth.CookieName, int64(0))
 
+	// the queue and in-flight requests outlive the signal, they are stopped by shutdown
+	sigCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	slog.Info("Initializing database")
 	db, err := database.New(a.Database)
 	if err != nil {
 		return fmt.Errorf("failed to create database %w", err)
 	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("failed to close database", "err", err)
+		}
+	}()
 
 	slog.Info("Initializing oauth")
 	a.Auth.Initialize(a.Pages, a.Gitea, db)
@@ -113,15 +172,56 @@ func (a *app) Action(ctx *cli.Context) error {
 	}
 
 	slog.Info("Initializing queue")
-	q, err := database.NewQueue(
-		ctx.Context,
-		fetchRepoFromPackages(c, db), fetchVersionFromPackages(a.Gitea, db),
-		fetchRepoFromReleases(c, db), fetchVersionFromReleases(c, a.Gitea, db),
-		fetchRepoFromBranches(c, db), fetchVersionFromBranches(c, db),
+	retry, err := a.Queue.RetryPolicies()
+	if err != nil {
+		return err
+	}
+	if err := a.Retention.Policy().Validate(); err != nil {
+		return fmt.Errorf("invalid retention policy: %w", err)
+	}
+	if err := validateAliasMode(a.Pages.AliasMode); err != nil {
+		return err
+	}
+	ordering, err := a.Ordering.Policy()
+	if err != nil {
+		return fmt.Errorf("invalid ordering strategy: %w", err)
+	}
+	rv := newRepoVersions(db, a.Retention.Policy(), ordering)
+	tasks, err := retry.Apply(
+		fetchRepoFromPackages(c, rv),
+		fetchVersionFromPackages(a.Gitea, db),
+		fetchRepoFromReleases(c, rv),
+		fetchVersionFromReleases(c, a.Gitea, db),
+		fetchRepoFromBranches(c, rv),
+		fetchVersionFromBranches(c, db),
 	)
+	if err != nil {
+		return err
+	}
+	q, err := database.NewQueue(ctx.Context, db, tasks...)
 	if err != nil {
 		return fmt.Errorf("failed to create queue %w", err)
 	}
+	defer q.Close()
+
+	// webhook reconciliation and garbage collection use the database,
+	// they are stopped and waited for before the queue and the database are closed on every return
+	backgroundCtx, cancelBackground := context.WithCancel(sigCtx)
+	var background sync.WaitGroup
+	defer func() {
+		cancelBackground()
+		background.Wait()
+	}()
+	reconciler := newWebhookReconciler(c, db, a.Gitea)
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		reconciler.Run(backgroundCtx, a.Webhooks.ReconcileInterval)
+	}()
+	go func() {
+		defer background.Done()
+		runGC(backgroundCtx, db, a.GC.Interval, a.Pages.MaxStaleness)
+	}()
 
 	slog.Info("Creating router")
 	// Service
@@ -146,178 +246,122 @@ func (a *app) Action(ctx *cli.Context) error {
 		_, _ = w.Write(templates.IndexJS)
 	})
 
-	r.Route(consts.HookPath, func(r chi.Router) {
-		r.Post("/{owner:^[^_].*}/{repo:^[^_].*}", func(w http.ResponseWriter, r *http.Request) {
-			owner := chi.URLParam(r, "owner")
-			repoName := chi.URLParam(r, "repo")
-			err := q.Enqueue(r.Context(), &FetchRepoFromPackages{
-				Owner: owner,
-				Repo:  repoName,
-			})
-			if err != nil {
-				slog.Error("failed to enqueue fetch repo", "err", err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusAccepted)
-			_, _ = w.Write([]byte("ok"))
-		})
+	r.With(verifiedGiteaWebhook(a.Gitea.HookSecret)).Route(consts.HookPath, func(r chi.Router) {
+		r.Post("/", giteaWebhook(c, q))
+		r.Post("/{owner:^[^_].*}/{repo:^[^_].*}", giteaWebhook(c, q))
 	})
 
-	// r.With(
-	// 	a.Auth.State.oauthStateVerrifier,
-	// 	tokenAuthenticator(GiteaPagesInfo{a.Gitea, a.Pages}),
-	// 	db.UserSessionFromToken, db.UserFromUserSession,
-	// 	authdClient,
-	// ).Get("/_register_webhook", func(w http.ResponseWriter, r *http.Request) {
-	// 	user, _ := database.UserFromContext(r.Context())
-	// 	client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
-	// 	hooks, err := allGiteaPages(r.Context(), func(_ context.Context, opts gitea.ListOptions) ([]*gitea.Hook, *gitea.Response, error) {
-	// 		hooks, resp, err := client.ListMyHooks(gitea.ListHooksOptions{
-	// 			ListOptions: opts,
-	// 		})
-	// 		for _, hook := range hooks {
-	// 			if hook.URL == a.Gitea.HookURL() {
-	// 				return []*gitea.Hook{hook}, resp, nil
-	// 			}
-	// 		}
-	// 		return []*gitea.Hook{}, resp, err
-	// 	})
-	// 	if err != nil {
-	// 		slog.Error("failed to get hooks", "user", user.GiteaUID, "err", err)
-	// 	}
-	// 	giteaWebhookClaims := map[string]any{
-	// 		a.Auth.CookieName:          int64(user.GiteaUID),
-	// 		consts.ThisIsAGiteaWebhook: true,
-	// 	}
-	// 	_, giteaWebhookTokenString, err := a.Auth.State.tokenAuth.Encode(giteaWebhookClaims)
-
-	// 	if len(hooks) == 0 {
-	// 		slog.Warn("no gitea hook found, creating one")
-	// 		hook, _, err := client.CreateMyHook(gitea.CreateHookOption{
-	// 			Type:                gitea.HookTypeGitea,
-	// 			AuthorizationHeader: giteaWebhookTokenString,
-	// 			Config: map[string]string{
-	// 				"url":          a.Gitea.HookURL(),
-	// 				"content_type": "json",
-	// 				"secret":       a.Gitea.HookSecret,
-	// 			},
-	// 			Events: []string{
-	// 				"package",
-	// 			},
-	// 			BranchFilter: "*",
-	// 			Active:       true,
-	// 		})
-	// 		if err != nil {
-	// 			slog.Error("failed to create gitea hook", "user", user.GiteaUID, "err", err)
-	// 			return
-	// 		}
-	// 		slog.Info("created gitea hook", "hook", hook)
-	// 	} else {
-
-	// 	}
-
-	// })
+	r.With(
+		middleware.NoCache,
+		sameOriginOnly(a.Pages),
+		a.Auth.State.oauthStateVerrifier,
+		tokenAuthenticator(GiteaPagesInfo{a.Gitea, a.Pages}),
+		db.UserSessionFromToken, db.UserFromUserSession,
+		authdClient,
+		adminOnly,
+	).Route("/_admin", func(r chi.Router) {
+		r.Post("/webhooks/reconcile", reconcileWebhooksHandler(reconciler))
+		r.Post("/gc", gcHandler(db, a.Pages.MaxStaleness))
+		r.Get("/queues", queuesHandler(GiteaPagesInfo{a.Gitea, a.Pages}, q))
+		r.Post("/queues/{queue}/{id}/cancel", queueJobHandler(q.Cancel))
+		r.Post("/queues/{queue}/{id}/requeue", queueJobHandler(q.Requeue))
+		r.Get("/domains", domainsHandler(db))
+		r.Put("/domains/{domain}", putDomainHandler(GiteaPagesInfo{a.Gitea, a.Pages}, db))
+		r.Delete("/domains/{domain}", deleteDomainHandler(db))
+	})
+
+	r.With(
+		middleware.NoCache,
+		sameOriginOnly(a.Pages),
+		a.Auth.State.oauthStateVerrifier,
+		tokenAuthenticator(GiteaPagesInfo{a.Gitea, a.Pages}),
+		db.UserSessionFromToken, db.UserFromUserSession,
+		authdClient,
+	).Route("/_api/repos/{owner}/{repo}", func(r chi.Router) {
+		r.Use(repoAdminOnly)
+		r.Get("/settings", getRepoSettingsHandler(db))
+		r.Put("/settings", putRepoSettingsHandler(db, q))
+		r.Get("/pin", getPinHandler(GiteaPagesInfo{a.Gitea, a.Pages}, db))
+		r.Put("/pin", putPinHandler(rv))
+		r.Post("/pin", postPinHandler(rv))
+		r.Delete("/pin", deletePinHandler(rv))
+	})
 
 	r.With(middleware.NoCache).Route("/_auth", a.Auth.State.routes)
 
 	r.Get("/{owner:^[^_].*}/{repo:^[^_].*}", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, r.URL.Path+"/", http.StatusTemporaryRedirect)
 	})
-	r.With(
+	pages := r.With(
 		a.Auth.State.oauthStateVerrifier,
 		tokenAuthenticator(GiteaPagesInfo{a.Gitea, a.Pages}),
 		db.UserSessionFromToken, db.UserFromUserSession,
 		authdClient,
-	).Get("/{owner:^[^_].*}/{repo:^[^_].*}/*", func(w http.ResponseWriter, r *http.Request) {
-		owner := chi.URLParam(r, "owner")
-		repoName := chi.URLParam(r, "repo")
-		repoName, repoVersion, _ := strings.Cut(repoName, "@")
-		path := chi.URLParam(r, "*")
-		client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
-		slog.Info("main endpoint hit", "owner", owner, "repo", repoName, "path", path)
-		_, _, err := client.GetRepo(owner, repoName)
-		if err != nil {
-			slog.Error("failed to get repo info", "err", err)
-			loginRequired(GiteaPagesInfo{a.Gitea, a.Pages}, w, r)
-			return
-		}
-		repotypes, err := allGiteaPages(r.Context(), func(_ context.Context, opts gitea.ListOptions) ([]types.RepoType, *gitea.Response, error) {
-			topics, rsp, lterr := client.ListRepoTopics(owner, repoName, gitea.ListRepoTopicsOptions{ListOptions: opts})
-			if lterr != nil {
-				return nil, nil, lterr
-			}
-			var ret []types.RepoType
-			for _, topic := range topics {
-				name := strings.TrimPrefix(topic, consts.PagesLabelPrefix)
-				if name == topic { // did not have prefix
-					slog.Info("topic does not contain", "label", topic, "prefix", consts.PagesLabelPrefix)
-					continue
-				}
-				var rt types.RepoType
-				rt, lterr = types.ParseRepoType(name)
-				if lterr != nil {
-					slog.Error("Failed to parse pages- topic part", "part", name)
-					continue
-				}
-				ret = append(ret, rt)
-			}
-			return ret, rsp, lterr
-		})
-		if err != nil {
-			slog.Error("failed to get repo type", "err", err)
-			errorPage(GiteaPagesInfo{a.Gitea, a.Pages}, err, w)
-			return
-		}
-		if len(repotypes) != 1 {
-			b := strings.Builder{}
-			if len(repotypes) == 0 {
-				b.WriteString("No suitable topics on this repo")
-			} else {
-				b.WriteString("Too many topics ")
-			}
-			b.WriteString(", expected one of topics: ")
-			for i, v := range types.RepoTypeNames() {
-				if i != 0 {
-					b.WriteString(", ")
-				}
-				b.WriteString(consts.PagesLabelPrefix)
-				b.WriteString(v)
-			}
-			err = errors.New(b.String())
-			slog.Error("failed to find repo type", "err", err)
-			errorPage(GiteaPagesInfo{a.Gitea, a.Pages}, err, w)
-			return
-		}
-
-		// client has access to the repo, let's check if we have the page
-		// input := r.Context().Value(httpin.Input).(*types.RepoVersion)
-		data, fetched, err := requestPageData(&types.RepoFileAtVersion{
-			Repo:    types.Repo{Owner: owner, Repo: repoName},
-			File:    path,
-			Version: repoVersion,
-		}, repotypes[0], db, q)
-		if err != nil {
-			slog.Error("failed to get page data", "err", err)
-			errorPage(GiteaPagesInfo{a.Gitea, a.Pages}, err, w)
-			return
-		}
-		if !fetched {
-			slog.Error("page not found")
-			preparationPage(GiteaPagesInfo{a.Gitea, a.Pages}, w)
-			return
-		}
-		w.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(path)))
-		_, err = w.Write(data)
-		if err != nil {
-			slog.Error("failed to write data", "err", err)
-			return
-		}
-	})
+	)
+	iso := newIsolation(a.Isolation, a.Pages, &a.Auth)
+	pageHandler := pagesHandler(GiteaPagesInfo{a.Gitea, a.Pages}, db, q, pathPageRequest, iso)
+	pages.Get("/{owner:^[^_].*}/{repo:^[^_].*}/*", pageHandler)
+	pages.Head("/{owner:^[^_].*}/{repo:^[^_].*}/*", pageHandler)
+	// retries of failed fetches
+	pages.With(sameOriginOnly(a.Pages)).Post("/{owner:^[^_].*}/{repo:^[^_].*}/*", pageHandler)
+
+	// custom domains serve a single repository at the root, owner subdomains serve repositories of the owner
+	sitesRouter := func(parse pageRequestParser, iso *isolation) chi.Router {
+		sr := chi.NewRouter()
+		sr.Use(httplog.RequestLogger(logger))
+		sr.With(middleware.NoCache).Route("/_auth", a.Auth.State.domainRoutes)
+		sitePages := sr.With(a.Auth.State.domainUser, authdClient)
+		sitePageHandler := pagesHandler(GiteaPagesInfo{a.Gitea, a.Pages}, db, q, parse, iso)
+		sitePages.Get("/*", sitePageHandler)
+		sitePages.Head("/*", sitePageHandler)
+		sitePages.With(siteOriginOnly(a.Pages)).Post("/*", sitePageHandler)
+		return sr
+	}
 
 	slog.Info("starting server", "addr", a.Server.Addr)
-	server := &http.Server{Addr: a.Server.Addr, Handler: r, BaseContext: func(net.Listener) context.Context { return ctx.Context }}
-	return server.ListenAndServe()
+	// a custom domain is the origin of a single site, it is never isolated
+	var isolated http.Handler
+	if iso != nil {
+		ir := chi.NewRouter()
+		ir.Use(httplog.RequestLogger(logger))
+		ir.Get("/*", iso.handler(GiteaPagesInfo{a.Gitea, a.Pages}, db, q))
+		ir.Head("/*", iso.handler(GiteaPagesInfo{a.Gitea, a.Pages}, db, q))
+		ir.With(siteOriginOnly(a.Pages)).Post("/*", iso.handler(GiteaPagesInfo{a.Gitea, a.Pages}, db, q))
+		isolated = ir
+	}
+	handler := hostRouter(
+		GiteaPagesInfo{a.Gitea, a.Pages}, db, iso, r,
+		sitesRouter(domainPageRequest, nil), sitesRouter(subdomainPageRequest(a.Pages), iso), isolated,
+	)
+	// event streams do not end on their own, they are closed once shutdown starts
+	closing := make(chan struct{})
+	server := &http.Server{Addr: a.Server.Addr, Handler: handler, BaseContext: func(net.Listener) context.Context {
+		return withServerClosing(ctx.Context, closing)
+	}}
+	server.RegisterOnShutdown(func() { close(closing) })
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	select {
+	case err = <-serverErr:
+		return err
+	case <-sigCtx.Done():
+	}
+	// a second signal kills the server right away
+	stop()
+
+	slog.Info("shutting down", "timeout", a.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to wait for in-flight requests", "err", err)
+	}
+	if err := q.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to wait for running jobs, they will be replayed on the next start", "err", err)
+	}
+	slog.Info("pages server stopped")
+	return nil
 }
 
 func indexPage(gi GiteaPagesInfo, w http.ResponseWriter) {
@@ -327,50 +371,71 @@ func indexPage(gi GiteaPagesInfo, w http.ResponseWriter) {
 	}{
 		Info: gi,
 	}); err != nil {
+		// the response is already started, the template error can only be logged
 		slog.Error("failed to execute index template", "err", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
+// loginURL is the login link for the request, custom domains and owner subdomains log in
+// on the pages server and get the session handed over
+func loginURL(gi GiteaPagesInfo, r *http.Request) string {
+	if hostName(r.Host) != gi.Pages.Host() {
+		scheme := "http"
+		if gi.Pages.Secure() {
+			scheme = "https"
+		}
+		ret := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		return strings.TrimSuffix(gi.Pages.URL, "/") + "/_auth/handoff?" + url.Values{"return": {ret.String()}}.Encode()
+	}
+	return "/_auth/login?" + url.Values{"redirect": {r.URL.Path}}.Encode()
+}
+
 func loginRequired(gi GiteaPagesInfo, w http.ResponseWriter, r *http.Request) {
-	reqPath := r.URL.Path
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
 	if err := templates.Login.Execute(w, struct {
 		Info     GiteaPagesInfo
-		Redirect string
+		LoginURL string
 	}{
 		Info:     gi,
-		Redirect: reqPath,
+		LoginURL: loginURL(gi, r),
 	}); err != nil {
+		// the response is already started, the template error can only be logged
 		slog.Error("failed to execute login template", "err", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
-func preparationPage(gi GiteaPagesInfo, w http.ResponseWriter) {
+// preparationPage shows the progress of the fetch from progressURL and reloads once the page is fetched
+func preparationPage(gi GiteaPagesInfo, w http.ResponseWriter, progressURL string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the request is accepted, the page is served once it is fetched
+	w.WriteHeader(http.StatusAccepted)
 	if err := templates.Preparation.Execute(w, struct {
-		Info GiteaPagesInfo
+		Info        GiteaPagesInfo
+		ProgressURL string
 	}{
-		Info: gi,
+		Info:        gi,
+		ProgressURL: progressURL,
 	}); err != nil {
+		// the response is already started, the template error can only be logged
 		slog.Error("failed to execute preparation template", "err", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
-func errorPage(gi GiteaPagesInfo, errIn error, w http.ResponseWriter) {
+func errorPage(gi GiteaPagesInfo, status int, errIn error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
 	if err := templates.Error.Execute(w, struct {
 		Info  GiteaPagesInfo
+		Title string
 		Error string
 	}{
 		Info:  gi,
+		Title: http.StatusText(status),
 		Error: errIn.Error(),
 	}); err != nil {
-		slog.Error("failed to execute error template", "err", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		// the status is already written, the template error can only be logged
+		slog.Error("failed to execute error template", "status", status, "err", err)
 	}
 }
 
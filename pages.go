@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/consts"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// pageRequest is a request for a file of a repository site
+type pageRequest struct {
+	Repo    types.Repo
+	Version string
+	Path    string
+	// Base is the URL path of the site root
+	Base string
+}
+
+// pageRequestParser extracts the repository, the version and the path from the request,
+// c is the Gitea client of the user
+type pageRequestParser func(r *http.Request, c *gitea.Client) (pageRequest, error)
+
+// pathPageRequest parses /{owner}/{repo}[@version]/{path}
+func pathPageRequest(r *http.Request, _ *gitea.Client) (pageRequest, error) {
+	owner := chi.URLParam(r, "owner")
+	repoSegment := chi.URLParam(r, "repo")
+	repoName, repoVersion, _ := strings.Cut(repoSegment, "@")
+	return pageRequest{
+		Repo:    types.Repo{Owner: owner, Repo: repoName},
+		Version: repoVersion,
+		Path:    chi.URLParam(r, "*"),
+		Base:    "/" + owner + "/" + repoSegment + "/",
+	}, nil
+}
+
+// rootPageRequest parses [@version/]{path} of a repository served at the root of a domain
+func rootPageRequest(repo types.Repo, sitePath string) pageRequest {
+	pr := pageRequest{
+		Repo: repo,
+		Path: sitePath,
+		Base: "/",
+	}
+	if strings.HasPrefix(pr.Path, "@") {
+		version, path, _ := strings.Cut(pr.Path[1:], "/")
+		pr.Version, pr.Path = version, path
+		pr.Base = "/@" + version + "/"
+	}
+	return pr
+}
+
+// pagesHandler checks that the user has access to the repository and serves its site,
+// with iso the site is served from its isolated origin instead
+func pagesHandler(gi GiteaPagesInfo, db *database.Database, q *database.Queue, parse pageRequestParser, iso *isolation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
+		pr, err := parse(r, client)
+		if err != nil {
+			slog.Error("failed to resolve site", "err", err)
+			status := giteaErrorStatus(err)
+			if status == http.StatusUnauthorized {
+				loginRequired(gi, w, r)
+				return
+			}
+			errorPage(gi, status, err, w)
+			return
+		}
+		if pr.Path == "" && !strings.HasSuffix(r.URL.Path, "/") {
+			// relative links of the site need the trailing slash
+			target := url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+			return
+		}
+		owner, repoName, path := pr.Repo.Owner, pr.Repo.Repo, pr.Path
+		slog.Info("main endpoint hit", "owner", owner, "repo", repoName, "path", path)
+		_, rsp, err := client.GetRepo(owner, repoName)
+		if err != nil {
+			slog.Error("failed to get repo info", "err", err)
+			status := giteaErrorStatus(wrapGiteaError(rsp, err))
+			if status == http.StatusUnauthorized {
+				loginRequired(gi, w, r)
+				return
+			}
+			errorPage(gi, status, err, w)
+			return
+		}
+		repotypes, err := repoPagesTypes(r.Context(), client, owner, repoName)
+		if err != nil {
+			slog.Error("failed to get repo type", "err", err)
+			errorPage(gi, giteaErrorStatus(err), err, w)
+			return
+		}
+		if len(repotypes) != 1 {
+			b := strings.Builder{}
+			if len(repotypes) == 0 {
+				b.WriteString("No suitable topics on this repo")
+			} else {
+				b.WriteString("Too many topics ")
+			}
+			b.WriteString(", expected one of topics: ")
+			for i, v := range types.RepoTypeNames() {
+				if i != 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(consts.PagesLabelPrefix)
+				b.WriteString(v)
+			}
+			err = errors.New(b.String())
+			slog.Error("failed to find repo type", "err", err)
+			errorPage(gi, http.StatusNotFound, err, w)
+			return
+		}
+
+		if iso != nil {
+			// the site is served from its own origin
+			iso.redirect(w, r, gi, db, pr, repotypes[0])
+			return
+		}
+		serveRepoSite(w, r, gi, db, q, pr, repotypes[0])
+	}
+}
+
+// serveRepoSite serves a file of the site once the access to the repository is checked,
+// versionsFile and progressFile are served under every version, posts to progressFile retry failed fetches
+func serveRepoSite(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, db *database.Database, q *database.Queue, pr pageRequest, rt types.RepoType) {
+	repo, repoVersion := pr.Repo, pr.Version
+	if r.Method == http.MethodPost && pr.Path != progressFile {
+		errorPage(gi, http.StatusMethodNotAllowed, errors.New("method not allowed"), w)
+		return
+	}
+	if pr.Path == versionsFile {
+		serveVersions(w, gi, db, q, repo, rt)
+		return
+	}
+	if pr.Path == progressFile {
+		if r.Method == http.MethodPost {
+			retryFetch(w, r, gi, db, q, pr, rt)
+			return
+		}
+		serveProgress(w, r, gi, db, repo, repoVersion)
+		return
+	}
+	site, fetched, err := requestSiteVersion(repo, repoVersion, rt, db, q)
+	if errors.Is(err, ErrVersionNotFound) {
+		slog.Info("version not found", "repo", repo, "version", repoVersion)
+		if err := forgetSiteVersion(db, repo, repoVersion); err != nil {
+			slog.Error("failed to forget served version", "repo", repo, "version", repoVersion, "err", err)
+		}
+		// a missing version has no 404 page, use the one of the latest version
+		latest, latestFetched, lerr := requestSiteVersion(repo, "", rt, db, q)
+		if lerr != nil || !latestFetched {
+			errorPage(gi, http.StatusNotFound, err, w)
+			return
+		}
+		serveNotFound(w, r, gi, db, latest, err)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get site version", "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	stale := false
+	if !fetched {
+		// keep serving the last served version while the requested one is fetched
+		staleSite, ok, err := staleSiteVersion(db, site, repoVersion, gi.Pages.MaxStaleness)
+		if err != nil {
+			slog.Error("failed to get stale site version", "repo", repo, "version", repoVersion, "err", err)
+		}
+		if ok {
+			slog.Info("serving stale version while fetching", "repo", repo, "version", staleSite.Version.Version, "fetching", site.Version.Version)
+			site, fetched, stale = staleSite, true, true
+		}
+	}
+	if site.Alias != "" && gi.Pages.AliasMode == aliasRedirect {
+		redirectAlias(w, r, pr, site.Version.Version)
+		return
+	}
+	if !fetched {
+		failure, failed, err := activeFetchFailure(db, repo, site.Version.Version)
+		if err != nil {
+			slog.Error("failed to get fetch failure", "repo", repo, "version", site.Version.Version, "err", err)
+		}
+		if failed {
+			fetchFailedPage(gi, w, r, pr, site.Version.Version, failure)
+			return
+		}
+		slog.Error("page not found")
+		preparationPage(gi, w, pr.Base+progressFile)
+		return
+	}
+	if stale {
+		w.Header().Set("Cache-Control", "no-cache")
+		if gi.Pages.StaleHeader != "" {
+			w.Header().Set(gi.Pages.StaleHeader, "refreshing")
+		}
+	} else if err := rememberSiteVersion(db, site, repoVersion); err != nil {
+		slog.Error("failed to remember served version", "repo", repo, "version", repoVersion, "err", err)
+	}
+	serveSite(w, r, gi, db, site, pr.Base, pr.Path)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/templates"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+var errUnknownVersion = errors.New("unknown version")
+
+// pinStatus is the pin of a repository together with versions it can be pinned to
+type pinStatus struct {
+	Pin      *types.RepoPin  `json:"pin"`
+	Latest   string          `json:"latest"`
+	Versions []types.Version `json:"versions"`
+}
+
+func getPinStatus(db *database.Database, repo types.Repo) (pinStatus, error) {
+	var status pinStatus
+	pin, ok, err := db.RepoPins().Get(repo)
+	if err != nil {
+		return status, fmt.Errorf("failed to get repo pin: %w", err)
+	}
+	if ok {
+		status.Pin = &pin
+	}
+	repoInfo, _, err := db.RepoPages().Get(repo)
+	if err != nil {
+		return status, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	status.Latest = repoInfo.Latest.Version
+	status.Versions = repoInfo.Versions
+	return status, nil
+}
+
+// setPin pins the latest version of the repository to version, an empty version removes the pin.
+// The latest version is selected again right away, refreshes of the repository keep the pin.
+func setPin(r *http.Request, rv *repoVersions, repo types.Repo, version string) (pinStatus, error) {
+	if version == "" {
+		if err := rv.db.RepoPins().Delete(repo); err != nil {
+			return pinStatus{}, fmt.Errorf("failed to delete repo pin: %w", err)
+		}
+		slog.Info("repo unpinned", "repo", repo)
+	} else {
+		repoInfo, _, err := rv.db.RepoPages().Get(repo)
+		if err != nil {
+			return pinStatus{}, fmt.Errorf("failed to get repo info: %w", err)
+		}
+		if _, ok := repoInfo.Version(version); !ok {
+			return pinStatus{}, fmt.Errorf("%w %q", errUnknownVersion, version)
+		}
+		pin := types.RepoPin{Version: version, PinnedAt: time.Now()}
+		client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
+		if user, _, err := client.GetMyUserInfo(); err == nil {
+			pin.PinnedBy = user.UserName
+		} else {
+			slog.Warn("failed to get current user", "err", err)
+		}
+		if err := rv.db.RepoPins().Set(repo, pin); err != nil {
+			return pinStatus{}, fmt.Errorf("failed to set repo pin: %w", err)
+		}
+		slog.Info("repo pinned", "repo", repo, "version", version, "by", pin.PinnedBy)
+	}
+	if _, err := rv.RefreshLatest(repo); err != nil {
+		return pinStatus{}, fmt.Errorf("failed to refresh latest version: %w", err)
+	}
+	return getPinStatus(rv.db, repo)
+}
+
+func pinError(w http.ResponseWriter, repo types.Repo, err error) {
+	if errors.Is(err, errUnknownVersion) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Error("failed to update repo pin", "repo", repo, "err", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// getPinHandler shows the pin of the repository as JSON or, for browsers, as an HTML page
+func getPinHandler(gi GiteaPagesInfo, db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := r.Context().Value(repoCtxKey{}).(types.Repo)
+		status, err := getPinStatus(db, repo)
+		if err != nil {
+			slog.Error("failed to get repo pin", "repo", repo, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !wantsHTML(r) {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		if err := templates.Pin.Execute(w, struct {
+			Info GiteaPagesInfo
+			Repo types.Repo
+			pinStatus
+		}{
+			Info:      gi,
+			Repo:      repo,
+			pinStatus: status,
+		}); err != nil {
+			slog.Error("failed to execute pin template", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// putPinHandler pins the latest version of the repository to {"version": "..."}
+func putPinHandler(rv *repoVersions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := r.Context().Value(repoCtxKey{}).(types.Repo)
+		var body struct {
+			Version string `json:"version"`
+		}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode pin: %s", err), http.StatusBadRequest)
+			return
+		}
+		if body.Version == "" {
+			http.Error(w, "version is required", http.StatusBadRequest)
+			return
+		}
+		status, err := setPin(r, rv, repo, body.Version)
+		if err != nil {
+			pinError(w, repo, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// deletePinHandler removes the pin, the latest version is selected by the ordering again
+func deletePinHandler(rv *repoVersions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := r.Context().Value(repoCtxKey{}).(types.Repo)
+		status, err := setPin(r, rv, repo, "")
+		if err != nil {
+			pinError(w, repo, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// postPinHandler handles the form of the pin page, an empty version removes the pin
+func postPinHandler(rv *repoVersions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := r.Context().Value(repoCtxKey{}).(types.Repo)
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse form: %s", err), http.StatusBadRequest)
+			return
+		}
+		status, err := setPin(r, rv, repo, r.PostForm.Get("version"))
+		if err != nil {
+			pinError(w, repo, err)
+			return
+		}
+		if wantsHTML(r) {
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
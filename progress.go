@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+const (
+	// progressFile is the status of the fetch of a site version, available under every version of the site
+	progressFile = "_progress"
+	// progressInterval throttles updates of bytes and files and the polling of event streams
+	progressInterval = 500 * time.Millisecond
+	// progressStreamTTL closes event streams, browsers reconnect to them
+	progressStreamTTL = time.Minute
+)
+
+// fetchProgress reports the progress of a running fetch to db.FetchProgress,
+// methods of a nil fetchProgress do nothing
+type fetchProgress struct {
+	db       *database.Database
+	key      string
+	attempt  database.JobAttempt
+	mu       sync.Mutex
+	progress types.FetchProgress
+	reported time.Time
+}
+
+// newFetchProgress starts reporting the progress of a fetch of the version of the repository,
+// an empty versionName is the listing of versions. Fetches run outside of the queue have a single attempt.
+func newFetchProgress(ctx context.Context, db *database.Database, repo types.Repo, versionName string, phase types.FetchPhase) *fetchProgress {
+	p := &fetchProgress{db: db, key: repoVersionKey(repo, versionName)}
+	attempt, ok := database.JobAttemptFromContext(ctx)
+	if !ok {
+		attempt = database.JobAttempt{Attempt: 1, MaxAttempts: 1}
+	}
+	p.attempt = attempt
+	p.progress = types.FetchProgress{Phase: phase, BytesTotal: -1, StartedAt: time.Now()}
+	p.report(true)
+	return p
+}
+
+// report stores the progress, unless forced at most once every progressInterval, p.mu must be held
+func (p *fetchProgress) report(force bool) {
+	if !force && time.Since(p.reported) < progressInterval {
+		return
+	}
+	p.reported = time.Now()
+	if err := p.db.FetchProgress().Set(p.key, p.progress); err != nil {
+		slog.Warn("failed to report fetch progress", "key", p.key, "err", err)
+	}
+}
+
+// update changes the progress and reports it
+func (p *fetchProgress) update(force bool, f func(progress *types.FetchProgress)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f(&p.progress)
+	p.report(force)
+}
+
+// Phase starts the next phase of the fetch
+func (p *fetchProgress) Phase(phase types.FetchPhase) {
+	p.update(true, func(progress *types.FetchProgress) {
+		progress.Phase = phase
+	})
+}
+
+// Download starts the download phase and counts bytes read from r, total is -1 if it is unknown
+func (p *fetchProgress) Download(r io.Reader, total int64) io.Reader {
+	p.update(true, func(progress *types.FetchProgress) {
+		progress.Phase = types.FetchPhaseDownloading
+		progress.BytesTotal = total
+	})
+	return &progressReader{r: r, p: p}
+}
+
+// Unzip starts the unzip phase of an archive with total files
+func (p *fetchProgress) Unzip(total int) {
+	p.update(true, func(progress *types.FetchProgress) {
+		progress.Phase = types.FetchPhaseUnzipping
+		progress.FilesTotal = total
+	})
+}
+
+// FileUnzipped counts a stored file of the archive
+func (p *fetchProgress) FileUnzipped() {
+	p.update(false, func(progress *types.FetchProgress) {
+		progress.FilesUnzipped++
+	})
+}
+
+// Finish records the result of the fetch and removes its progress, the failure is recorded first
+// so a failed fetch does not look queued in between
+func (p *fetchProgress) Finish(err error) {
+	if p == nil {
+		return
+	}
+	recordFetchResult(p.db, p.key, p.attempt, err)
+	if err := p.db.FetchProgress().Delete(p.key); err != nil {
+		slog.Warn("failed to remove fetch progress", "key", p.key, "err", err)
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *fetchProgress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.update(err == io.EOF, func(progress *types.FetchProgress) {
+		progress.BytesDownloaded += int64(n)
+	})
+	return n, err
+}
+
+// fetchStatus is the status of the fetch of a site version, Version is empty while versions are listed
+// and the progress is omitted once the version is ready
+type fetchStatus struct {
+	Ready   bool   `json:"ready"`
+	Version string `json:"version,omitempty"`
+	*types.FetchProgress
+	// Failure is the failure of the fetch once its job ran out of attempts, unless it is retried
+	Failure *types.FetchFailure `json:"failure,omitempty"`
+	// LastAttempt is the failure of the last attempt while the queue retries the fetch
+	LastAttempt *types.FetchFailure `json:"last_attempt,omitempty"`
+}
+
+// getFetchStatus finds the status of the fetch of the site version requested with versionName,
+// errors of failures are only included for administrators, admin is only called for failures
+func getFetchStatus(db *database.Database, repo types.Repo, versionName string, admin func() bool) (fetchStatus, error) {
+	var status fetchStatus
+	repoInfo, ok, err := db.RepoPages().Get(repo)
+	if err != nil {
+		return status, fmt.Errorf("failed to get repo info %w", err)
+	}
+	if ok {
+		version, _, err := resolveVersion(db, repoInfo, versionName)
+		if err != nil {
+			return status, err
+		}
+		status.Version = version.Version
+		if version.SHA != "" {
+			// metadata marks the version as fetched
+			_, status.Ready, err = db.PagesMetadata().Get(version.SHA)
+			if err != nil {
+				return status, fmt.Errorf("failed to get pages metadata %w", err)
+			}
+		}
+	}
+	if status.Ready {
+		return status, nil
+	}
+	failure, failed, err := lastFetchFailure(db, repo, status.Version)
+	if err != nil {
+		return status, err
+	}
+	if failed && !admin() {
+		failure.Error = ""
+	}
+	switch {
+	case failed && failure.Dead:
+		status.Failure = &failure
+	case failed:
+		status.LastAttempt = &failure
+	}
+	progress, ok, err := db.FetchProgress().Get(repoVersionKey(repo, status.Version))
+	if err != nil {
+		return status, fmt.Errorf("failed to get fetch progress %w", err)
+	}
+	if !ok {
+		// the fetch has not started yet
+		progress = types.FetchProgress{Phase: types.FetchPhaseQueued, BytesTotal: -1}
+	}
+	status.FetchProgress = &progress
+	return status, nil
+}
+
+type serverClosingKey struct{}
+
+// withServerClosing stores the channel which is closed when the server starts shutting down
+func withServerClosing(ctx context.Context, closing <-chan struct{}) context.Context {
+	return context.WithValue(ctx, serverClosingKey{}, closing)
+}
+
+// serverClosing returns the channel closed on shutdown, nil which blocks forever outside of the server
+func serverClosing(ctx context.Context) <-chan struct{} {
+	closing, _ := ctx.Value(serverClosingKey{}).(<-chan struct{})
+	return closing
+}
+
+// serveProgress serves the status of the fetch of the site version as JSON, or as a stream of
+// server-sent events for clients accepting text/event-stream
+func serveProgress(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, db *database.Database, repo types.Repo, versionName string) {
+	// checking the user asks Gitea, it is done once per request and only when there is a failure to show
+	admin := sync.OnceValue(func() bool { return isSiteAdmin(r) })
+	status, err := getFetchStatus(db, repo, versionName, admin)
+	if errors.Is(err, ErrVersionNotFound) {
+		errorPage(gi, http.StatusNotFound, err, w)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get fetch status", "repo", repo, "version", versionName, "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, ok := w.(http.Flusher)
+	if !ok || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	timeout := time.After(progressStreamTTL)
+	var sent []byte
+	for {
+		data, err := json.Marshal(status)
+		if err != nil {
+			slog.Error("failed to marshal fetch status", "err", err)
+			return
+		}
+		if string(data) != string(sent) {
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+			sent = data
+		}
+		if status.Ready || status.Failure != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverClosing(r.Context()):
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+		}
+		status, err = getFetchStatus(db, repo, versionName, admin)
+		if err != nil {
+			slog.Error("failed to get fetch status", "repo", repo, "version", versionName, "err", err)
+			return
+		}
+	}
+}
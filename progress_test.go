@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestGetFetchStatusChecksAdminOnFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		failed  bool
+		admin   bool
+		checked bool
+		error   string
+	}{
+		{name: "no failure"},
+		{name: "failure for visitors", failed: true, checked: true},
+		{name: "failure for admins", failed: true, admin: true, checked: true, error: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := database.New(database.Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := types.Repo{Owner: "owner", Repo: "repo"}
+			v1 := types.Version{Version: "v1", SHA: "v1"}
+			if err := db.RepoPages().Set(repo, types.RepoInfo{Repo: repo, Latest: v1, Versions: []types.Version{v1}}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.failed {
+				recordFetchResult(db, repoVersionKey(repo, "v1"), database.JobAttempt{Attempt: 1, MaxAttempts: 1}, errors.New("connection refused"))
+			}
+			checked := false
+			status, err := getFetchStatus(db, repo, "v1", func() bool {
+				checked = true
+				return tt.admin
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checked != tt.checked {
+				t.Errorf("admin checked = %v, want %v", checked, tt.checked)
+			}
+			if (status.Failure != nil) != tt.failed || (status.Failure != nil && status.Failure.Error != tt.error) {
+				t.Errorf("failure = %+v, want error %q", status.Failure, tt.error)
+			}
+		})
+	}
+}
+
+func TestServeProgressEndsOnShutdown(t *testing.T) {
+	db, err := database.New(database.Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := types.Repo{Owner: "owner", Repo: "repo"}
+	v1 := types.Version{Version: "v1"}
+	if err := db.RepoPages().Set(repo, types.RepoInfo{Repo: repo, Latest: v1, Versions: []types.Version{v1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closing := make(chan struct{})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveProgress(w, r, GiteaPagesInfo{}, db, repo, "v1")
+		}),
+		BaseContext: func(net.Listener) context.Context {
+			return withServerClosing(context.Background(), closing)
+		},
+	}
+	server.RegisterOnShutdown(func() { close(closing) })
+	go func() { _ = server.Serve(l) }()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/owner/repo/@v1/_progress", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	// the first event means the stream is open
+	line, err := bufio.NewReader(rsp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown = %v, want the stream to be closed", err)
+	}
+}
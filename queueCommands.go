@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	clive "github.com/ASMfreaK/clive2"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/urfave/cli/v2"
+)
+
+// openStoppedDatabase opens the database for commands which need the server to be stopped,
+// the running server holds the lock of the database and offers the same at the admin endpoint
+func openStoppedDatabase(params database.Params, endpoint string) (*database.Database, error) {
+	db, err := database.New(params)
+	if errors.Is(err, database.ErrLocked) {
+		return nil, fmt.Errorf("stop the server first or use %s of the running server: %w", endpoint, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database %w", err)
+	}
+	return db, nil
+}
+
+type DeadJobs struct {
+	*clive.Command `cli:"name:'dead-jobs',usage:'list fetch jobs which ran out of retry attempts and exit, the server must be stopped'"`
+}
+
+func (cmd *DeadJobs) Action(ctx *cli.Context) error {
+	a := cmd.Root(ctx).(*app)
+	db, err := openStoppedDatabase(a.Database, "/_admin/queues")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	jobs, err := db.DeadJobs()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(ctx.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tATTEMPTS\tFAILED AT\tJOB\tLAST ERROR")
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", job.Key(), job.Attempts, job.FailedAt.Format(time.RFC3339), job.Job, job.LastError)
+	}
+	return tw.Flush()
+}
+
+type RequeueDeadJobs struct {
+	*clive.Command `cli:"name:'requeue',usage:'move dead fetch jobs of the stopped server back to their queues, they run on its next start'"`
+	All            bool     `cli:"usage:'requeue all dead jobs'"`
+	Keys           []string `cli:"positional,usage:'keys of dead jobs to requeue'"`
+}
+
+func (cmd *RequeueDeadJobs) Action(ctx *cli.Context) error {
+	a := cmd.Root(ctx).(*app)
+	if !cmd.All && len(cmd.Keys) == 0 {
+		return errors.New("no jobs to requeue, pass keys of dead jobs or --all")
+	}
+	db, err := openStoppedDatabase(a.Database, "/_admin/queues")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	keys := cmd.Keys
+	if cmd.All {
+		jobs, err := db.DeadJobs()
+		if err != nil {
+			return err
+		}
+		keys = keys[:0]
+		for _, job := range jobs {
+			keys = append(keys, job.Key())
+		}
+	}
+	for _, key := range keys {
+		job, err := db.RequeueDeadJob(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.App.Writer, "requeued %s %s\n", job.Key(), job.Job)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+// parseRedirects parses a Netlify-style _redirects file:
+//
+//	/from/:placeholder/*  /to/:placeholder/:splat  [status][!]
+//
+// The status defaults to 301, a trailing ! forces the rule even if a file exists at the path.
+// Rules with conditions like Country= or query parameters are not supported and skipped.
+func parseRedirects(data []byte) []types.RedirectRule {
+	var rules []types.RedirectRule
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			slog.Warn("skipping malformed redirect rule", "line", line, "rule", text)
+			continue
+		}
+		rule := types.RedirectRule{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently}
+		valid := strings.HasPrefix(rule.From, "/")
+		for _, field := range fields[2:] {
+			status, force := strings.CutSuffix(field, "!")
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 200 || code > 599 {
+				valid = false
+				break
+			}
+			rule.Status, rule.Force = code, force
+		}
+		if !valid {
+			slog.Warn("skipping unsupported redirect rule", "line", line, "rule", text)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// matchPath matches the site path against a pattern with :placeholders and a trailing *,
+// returning values of placeholders and the splat
+func matchPath(pattern, sitePath string) (map[string]string, bool) {
+	from, got := splitPath(pattern), splitPath(sitePath)
+	params := make(map[string]string)
+	for i, segment := range from {
+		if segment == "*" && i == len(from)-1 {
+			params["splat"] = strings.Join(got[min(i, len(got)):], "/")
+			return params, true
+		}
+		if i >= len(got) {
+			return nil, false
+		}
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params[name] = got[i]
+			continue
+		}
+		if segment != got[i] {
+			return nil, false
+		}
+	}
+	return params, len(from) == len(got)
+}
+
+var placeholderRe = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// expandRedirect substitutes placeholders and the splat in To
+func expandRedirect(rule types.RedirectRule, params map[string]string) string {
+	return placeholderRe.ReplaceAllStringFunc(rule.To, func(s string) string {
+		if v, ok := params[s[1:]]; ok {
+			return v
+		}
+		return s
+	})
+}
+
+// redirectTarget is the result of applying redirect rules to a request
+type redirectTarget struct {
+	Rule types.RedirectRule
+	// To is the expanded target: a URL or a path relative to the site root
+	To string
+}
+
+func (t redirectTarget) IsRedirect() bool {
+	return t.Rule.Status >= 300 && t.Rule.Status < 400
+}
+
+// findRedirect returns the first rule matching the site path. Rules which are not forced
+// are skipped when the site has a file at the path.
+func findRedirect(rules []types.RedirectRule, sitePath string, exists func(string) bool) (redirectTarget, bool) {
+	for _, rule := range rules {
+		params, ok := matchPath(rule.From, sitePath)
+		if !ok {
+			continue
+		}
+		if !rule.Force && exists(sitePath) {
+			continue
+		}
+		return redirectTarget{Rule: rule, To: expandRedirect(rule, params)}, true
+	}
+	return redirectTarget{}, false
+}
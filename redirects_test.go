@@ -0,0 +1,113 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestParseRedirects(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		rules []types.RedirectRule
+	}{
+		{
+			name:  "default status",
+			in:    "/old /new",
+			rules: []types.RedirectRule{{From: "/old", To: "/new", Status: http.StatusMovedPermanently}},
+		},
+		{
+			name:  "status and force",
+			in:    "/blog/:year/* /posts/:year/:splat 302!",
+			rules: []types.RedirectRule{{From: "/blog/:year/*", To: "/posts/:year/:splat", Status: http.StatusFound, Force: true}},
+		},
+		{
+			name:  "rewrite",
+			in:    "/app/*  /index.html  200",
+			rules: []types.RedirectRule{{From: "/app/*", To: "/index.html", Status: http.StatusOK}},
+		},
+		{
+			name:  "comments and blank lines",
+			in:    "# moved\n\n  /a /b  \n# gone\n/c https://example.com/c 308\n",
+			rules: []types.RedirectRule{{From: "/a", To: "/b", Status: http.StatusMovedPermanently}, {From: "/c", To: "https://example.com/c", Status: http.StatusPermanentRedirect}},
+		},
+		{name: "missing target", in: "/lonely"},
+		{name: "relative source", in: "old /new"},
+		{name: "condition", in: "/ /fr 302 Country=fr"},
+		{name: "status out of range", in: "/a /b 99"},
+		{name: "query parameters", in: "/a id=:id /b/:id 301"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rules := parseRedirects([]byte(tt.in)); !slices.Equal(rules, tt.rules) {
+				t.Errorf("rules = %+v, want %+v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		params  map[string]string
+	}{
+		{pattern: "/", path: "/", params: map[string]string{}},
+		{pattern: "/old", path: "/old", params: map[string]string{}},
+		{pattern: "/old", path: "/old/", params: map[string]string{}},
+		{pattern: "/old", path: "/older"},
+		{pattern: "/old", path: "/old/page"},
+		{pattern: "/old/page", path: "/old"},
+		{pattern: "/old", path: "/"},
+		{pattern: "/blog/:year/:slug", path: "/blog/2024/hello", params: map[string]string{"year": "2024", "slug": "hello"}},
+		{pattern: "/blog/:year/:slug", path: "/blog/2024"},
+		{pattern: "/docs/*", path: "/docs/a/b.html", params: map[string]string{"splat": "a/b.html"}},
+		{pattern: "/docs/*", path: "/docs", params: map[string]string{"splat": ""}},
+		{pattern: "/docs/*", path: "/other/a"},
+		{pattern: "/*", path: "/anything/at/all", params: map[string]string{"splat": "anything/at/all"}},
+		{pattern: "/:lang/*", path: "/en/guide/intro", params: map[string]string{"lang": "en", "splat": "guide/intro"}},
+		{pattern: "/*/page", path: "/*/page", params: map[string]string{}},
+		{pattern: "/*/page", path: "/a/page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			params, ok := matchPath(tt.pattern, tt.path)
+			if ok != (tt.params != nil) || !maps.Equal(params, tt.params) {
+				t.Errorf("matchPath = %v, %v, want %v", params, ok, tt.params)
+			}
+		})
+	}
+}
+
+func TestFindRedirect(t *testing.T) {
+	rules := parseRedirects([]byte(`
+/exists /elsewhere
+/forced /elsewhere 302!
+/blog/:year/* /posts/:year/:splat
+/app/* /index.html 200
+`))
+	exists := func(p string) bool { return p == "/exists" || p == "/forced" }
+	tests := []struct {
+		path     string
+		to       string
+		redirect bool
+	}{
+		{path: "/exists"},
+		{path: "/forced", to: "/elsewhere", redirect: true},
+		{path: "/blog/2024/a/b", to: "/posts/2024/a/b", redirect: true},
+		{path: "/app/settings", to: "/index.html"},
+		{path: "/unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			target, ok := findRedirect(rules, tt.path, exists)
+			if ok != (tt.to != "") || target.To != tt.to || target.IsRedirect() != tt.redirect {
+				t.Errorf("findRedirect = %+v, %v, want %s (redirect %v)", target, ok, tt.to, tt.redirect)
+			}
+		})
+	}
+}
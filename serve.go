@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+)
+
+// etag is a strong validator of the page, pages and their compressed variants are stored by their content hash
+func (p pageData) etag() string {
+	return `"` + p.SHA.String() + `"`
+}
+
+// etagMatches reports whether the If-None-Match header lists etag, using weak comparison
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since, If-None-Match takes precedence
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modtime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified has a resolution of one second
+	return !modtime.Truncate(time.Second).After(t)
+}
+
+// serveSite serves the file at sitePath of the site version applying its redirect rules,
+// base is the URL path of the site root
+func serveSite(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, db *database.Database, site siteVersion, base, sitePath string) {
+	applyHeaders(w.Header(), site.Config.Headers, "/"+sitePath, gi.Pages.DeniedHeaders)
+	status := http.StatusOK
+	exists := func(p string) bool {
+		_, ok := site.file(strings.TrimPrefix(p, "/"))
+		return ok
+	}
+	if target, ok := findRedirect(site.Config.Redirects, "/"+sitePath, exists); ok {
+		slog.Info("redirect rule matched", "repo", site.Repo, "from", target.Rule.From, "to", target.To, "status", target.Rule.Status)
+		external := isAbsoluteURL(target.To)
+		switch {
+		case target.IsRedirect():
+			location := target.To
+			if !external {
+				location = base + strings.TrimPrefix(location, "/")
+				if r.URL.RawQuery != "" && !strings.Contains(location, "?") {
+					location += "?" + r.URL.RawQuery
+				}
+			}
+			http.Redirect(w, r, location, target.Rule.Status)
+			return
+		case external:
+			slog.Warn("proxying to external urls is not supported, ignoring the rule", "repo", site.Repo, "from", target.Rule.From, "to", target.To)
+		default:
+			sitePath, _, _ = strings.Cut(strings.TrimPrefix(target.To, "/"), "?")
+			status = target.Rule.Status
+		}
+	}
+
+	page, fetched, err := site.page(sitePath, acceptEncoding(r), db)
+	if errors.Is(err, ErrFileNotFound) {
+		slog.Info("page not found", "repo", site.Repo, "version", site.Version.Version, "path", sitePath)
+		serveNotFound(w, r, gi, db, site, err)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get page data", "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	if !fetched {
+		slog.Error("page data not found", "repo", site.Repo, "version", site.Version.Version, "path", sitePath)
+		preparationPage(gi, w, base+progressFile)
+		return
+	}
+	if status != http.StatusOK {
+		serveWithStatus(w, r, page, status)
+		return
+	}
+	servePage(w, r, page)
+}
+
+// acceptEncoding is the Accept-Encoding of the request, ranges are served from the file as is
+// because a slice of a compressed stream is useless to clients resuming downloads
+func acceptEncoding(r *http.Request) string {
+	if r.Header.Get("Range") != "" {
+		return ""
+	}
+	return r.Header.Get("Accept-Encoding")
+}
+
+func isAbsoluteURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// serveNotFound serves 404.html of the site with 404 Not Found or the server error page
+func serveNotFound(w http.ResponseWriter, r *http.Request, gi GiteaPagesInfo, db *database.Database, site siteVersion, errIn error) {
+	page, fetched, err := site.page(notFoundPage, r.Header.Get("Accept-Encoding"), db)
+	if err != nil || !fetched {
+		errorPage(gi, http.StatusNotFound, errIn, w)
+		return
+	}
+	serveWithStatus(w, r, page, http.StatusNotFound)
+}
+
+// notFoundPage is served with 404 Not Found for missing files when a site ships it at its root
+const notFoundPage = "404.html"
+
+// serveWithStatus writes the whole page with the status, validators and ranges do not apply
+func serveWithStatus(w http.ResponseWriter, r *http.Request, p pageData, status int) {
+	h := w.Header()
+	if len(p.File.Encodings) != 0 {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if p.Encoding != "" {
+		h.Set("Content-Encoding", p.Encoding)
+	}
+	h.Set("Content-Type", mime.TypeByExtension(path.Ext(p.File.Name)))
+	h.Set("Content-Length", strconv.Itoa(len(p.Data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(p.Data); err != nil {
+		slog.Error("failed to write data", "err", err)
+	}
+}
+
+// httpRange is a byte range of a page, end is exclusive
+type httpRange struct {
+	start, end int
+}
+
+func (hr httpRange) contentRange(size int) string {
+	return fmt.Sprintf("bytes %d-%d/%d", hr.start, hr.end-1, size)
+}
+
+var errUnsatisfiableRange = errors.New("no satisfiable range")
+
+// parseRange parses the Range header. Malformed headers are reported with ok == false
+// and must be ignored, errUnsatisfiableRange is returned when no range overlaps the page.
+func parseRange(header string, size int) (ranges []httpRange, ok bool, err error) {
+	specs, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, false, nil
+	}
+	total := 0
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, false, nil
+		}
+		var hr httpRange
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.Atoi(last)
+			if err != nil || n < 0 {
+				return nil, false, nil
+			}
+			if n == 0 {
+				continue
+			}
+			hr = httpRange{start: max(size-n, 0), end: size}
+		} else {
+			start, err := strconv.Atoi(first)
+			if err != nil || start < 0 {
+				return nil, false, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.Atoi(last); err != nil || end < start {
+					return nil, false, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			hr = httpRange{start: start, end: min(end, size-1) + 1}
+		}
+		total += hr.end - hr.start
+		ranges = append(ranges, hr)
+	}
+	if len(ranges) == 0 {
+		return nil, true, errUnsatisfiableRange
+	}
+	if total > size {
+		// overlapping ranges cost more than the whole page, serve it instead
+		return nil, false, nil
+	}
+	return ranges, true, nil
+}
+
+// ifRangeMatches evaluates If-Range, a strong ETag or the exact Last-Modified date must match
+func ifRangeMatches(r *http.Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !modtime.IsZero() && modtime.Truncate(time.Second).Equal(t)
+}
+
+// pageError replies with a plain error, dropping the encoding and validators of the page
+// which do not describe the error body
+func pageError(w http.ResponseWriter, error string, code int) {
+	h := w.Header()
+	for _, k := range []string{"Content-Encoding", "Vary", "ETag", "Last-Modified"} {
+		h.Del(k)
+	}
+	http.Error(w, error, code)
+}
+
+// servePage writes the page with validators, answering conditional requests with 304 Not Modified
+// and range requests with 206 Partial Content
+func servePage(w http.ResponseWriter, r *http.Request, p pageData) {
+	h := w.Header()
+	etag := p.etag()
+	modtime := p.Version.CreatedAt
+	h.Set("ETag", etag)
+	if !modtime.IsZero() {
+		h.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	h.Set("Accept-Ranges", "bytes")
+	if len(p.File.Encodings) != 0 {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if p.Encoding != "" {
+		h.Set("Content-Encoding", p.Encoding)
+	}
+	if notModified(r, etag, modtime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(p.File.Name))
+	size := len(p.Data)
+
+	status := http.StatusOK
+	body := p.Data
+	// compressed variants are always served whole, see acceptEncoding
+	if rh := r.Header.Get("Range"); rh != "" && p.Encoding == "" && ifRangeMatches(r, etag, modtime) {
+		ranges, ok, err := parseRange(rh, size)
+		switch {
+		case errors.Is(err, errUnsatisfiableRange):
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			pageError(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		case !ok:
+			// malformed ranges are ignored
+		case len(ranges) == 1:
+			status = http.StatusPartialContent
+			h.Set("Content-Range", ranges[0].contentRange(size))
+			body = p.Data[ranges[0].start:ranges[0].end]
+		default:
+			status = http.StatusPartialContent
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			for _, hr := range ranges {
+				part, err := mw.CreatePart(textproto.MIMEHeader{
+					"Content-Type":  {contentType},
+					"Content-Range": {hr.contentRange(size)},
+				})
+				if err != nil {
+					slog.Error("failed to create multipart range", "err", err)
+					pageError(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				_, _ = part.Write(p.Data[hr.start:hr.end])
+			}
+			_ = mw.Close()
+			contentType = "multipart/byteranges; boundary=" + mw.Boundary()
+			body = buf.Bytes()
+		}
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		slog.Error("failed to write data", "err", err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int
+		ranges []httpRange
+		ok     bool
+		err    error
+	}{
+		{header: "bytes=0-4", size: 10, ranges: []httpRange{{0, 5}}, ok: true},
+		{header: "bytes=5-", size: 10, ranges: []httpRange{{5, 10}}, ok: true},
+		{header: "bytes=-3", size: 10, ranges: []httpRange{{7, 10}}, ok: true},
+		{header: "bytes=-30", size: 10, ranges: []httpRange{{0, 10}}, ok: true},
+		{header: "bytes=8-20", size: 10, ranges: []httpRange{{8, 10}}, ok: true},
+		{header: "bytes=0-1, 4-5", size: 10, ranges: []httpRange{{0, 2}, {4, 6}}, ok: true},
+		{header: "bytes=0-1,,4-5", size: 10, ranges: []httpRange{{0, 2}, {4, 6}}, ok: true},
+		{header: "bytes=0-1, 20-30", size: 10, ranges: []httpRange{{0, 2}}, ok: true},
+		{header: "bytes=20-30", size: 10, ok: true, err: errUnsatisfiableRange},
+		{header: "bytes=-0", size: 10, ok: true, err: errUnsatisfiableRange},
+		{header: "bytes=0-9, 0-9", size: 10, ok: false},
+		{header: "bytes=5-4", size: 10, ok: false},
+		{header: "bytes=a-b", size: 10, ok: false},
+		{header: "bytes=5", size: 10, ok: false},
+		{header: "items=0-4", size: 10, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			ranges, ok, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if ok != tt.ok {
+				t.Errorf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(ranges, tt.ranges) {
+				t.Errorf("ranges = %v, want %v", ranges, tt.ranges)
+			}
+		})
+	}
+}
+
+func TestServePageRange(t *testing.T) {
+	page := pageData{
+		Data: []byte("0123456789"),
+		File: types.PageFile{
+			Name:      "file.txt",
+			SHA:       "plain",
+			Encodings: map[string]types.PageSHA256{"gzip": "gzipped"},
+		},
+		SHA: "plain",
+	}
+	compressed := page
+	compressed.Encoding = "gzip"
+	compressed.SHA = "gzipped"
+
+	tests := []struct {
+		name    string
+		page    pageData
+		header  http.Header
+		status  int
+		body    string
+		headers map[string]string
+		// parts are expected in a multipart body
+		parts []string
+	}{
+		{
+			name:    "whole page",
+			page:    page,
+			status:  http.StatusOK,
+			body:    "0123456789",
+			headers: map[string]string{"ETag": `"plain"`, "Accept-Ranges": "bytes"},
+		},
+		{
+			name:    "single range",
+			page:    page,
+			header:  http.Header{"Range": {"bytes=2-4"}},
+			status:  http.StatusPartialContent,
+			body:    "234",
+			headers: map[string]string{"Content-Range": "bytes 2-4/10", "Content-Length": "3"},
+		},
+		{
+			name:    "multiple ranges",
+			page:    page,
+			header:  http.Header{"Range": {"bytes=0-1,8-"}},
+			status:  http.StatusPartialContent,
+			headers: map[string]string{"Content-Range": ""},
+			parts:   []string{"Content-Range: bytes 0-1/10", "01", "Content-Range: bytes 8-9/10", "89"},
+		},
+		{
+			name:    "malformed range",
+			page:    page,
+			header:  http.Header{"Range": {"bytes=x-y"}},
+			status:  http.StatusOK,
+			body:    "0123456789",
+			headers: map[string]string{"Content-Range": ""},
+		},
+		{
+			name:    "unsatisfiable range",
+			page:    page,
+			header:  http.Header{"Range": {"bytes=20-"}},
+			status:  http.StatusRequestedRangeNotSatisfiable,
+			headers: map[string]string{"Content-Range": "bytes */10", "ETag": ""},
+		},
+		{
+			name:    "unsatisfiable range of a page with variants",
+			page:    page,
+			header:  http.Header{"Range": {"bytes=20-"}},
+			status:  http.StatusRequestedRangeNotSatisfiable,
+			headers: map[string]string{"Content-Encoding": "", "Vary": "", "ETag": ""},
+		},
+		{
+			name:    "range of a compressed page",
+			page:    compressed,
+			header:  http.Header{"Range": {"bytes=0-0"}},
+			status:  http.StatusOK,
+			body:    "0123456789",
+			headers: map[string]string{"Content-Encoding": "gzip", "Vary": "Accept-Encoding", "ETag": `"gzipped"`, "Content-Range": ""},
+		},
+		{
+			name:   "if-range mismatch",
+			page:   page,
+			header: http.Header{"Range": {"bytes=2-4"}, "If-Range": {`"other"`}},
+			status: http.StatusOK,
+			body:   "0123456789",
+		},
+		{
+			name:   "if-none-match",
+			page:   page,
+			header: http.Header{"If-None-Match": {`W/"plain"`}},
+			status: http.StatusNotModified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			servePage(w, r, tt.page)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			for k, v := range tt.headers {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+			if tt.parts == nil {
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "multipart/byteranges; boundary=") {
+				t.Errorf("Content-Type = %q, want multipart/byteranges", contentType)
+			}
+			for _, part := range tt.parts {
+				if !strings.Contains(w.Body.String(), part) {
+					t.Errorf("body %q misses %q", w.Body.String(), part)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{name: "compressed", header: http.Header{"Accept-Encoding": {"gzip, br"}}, want: "gzip, br"},
+		{name: "range", header: http.Header{"Accept-Encoding": {"gzip, br"}, "Range": {"bytes=100-"}}},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			r.Header = tt.header
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			if got := acceptEncoding(r); got != tt.want {
+				t.Errorf("acceptEncoding = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/go-chi/chi/v5"
+)
+
+type repoCtxKey struct{}
+
+// repoAdminOnly lets through only users which administer the repository {owner}/{repo}
+// or the Gitea site. Must be used after authenticatedGiteaClient.
+func repoAdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
+		repo := types.Repo{Owner: chi.URLParam(r, "owner"), Repo: chi.URLParam(r, "repo")}
+		giteaRepo, resp, err := client.GetRepo(repo.Owner, repo.Repo)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
+			slog.Error("failed to get repo", "repo", repo, "err", err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		if giteaRepo.Permissions == nil || !giteaRepo.Permissions.Admin {
+			user, _, err := client.GetMyUserInfo()
+			if err != nil {
+				slog.Error("failed to get current user", "err", err)
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+			if !user.IsAdmin {
+				slog.Warn("non-admin user tried to change repo settings", "user", user.UserName, "repo", repo)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), repoCtxKey{}, repo)))
+	})
+}
+
+func getRepoSettingsHandler(db *database.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := r.Context().Value(repoCtxKey{}).(types.Repo)
+		settings, _, err := db.RepoSettings().Get(repo)
+		if err != nil {
+			slog.Error("failed to get repo settings", "repo", repo, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
+
+// putRepoSettingsHandler replaces settings of the repository and refetches it to apply them
+func putRepoSettingsHandler(db *database.Database, q *database.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := r.Context().Value(repoCtxKey{}).(types.Repo)
+		var settings types.RepoSettings
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&settings); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode settings: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := db.RepoSettings().Set(repo, settings); err != nil {
+			slog.Error("failed to set repo settings", "repo", repo, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("repo settings updated", "repo", repo)
+
+		client := r.Context().Value(clientCtxKey{}).(*gitea.Client)
+		repotypes, err := repoPagesTypes(r.Context(), client, repo.Owner, repo.Repo)
+		if err != nil {
+			slog.Error("failed to get repo types", "repo", repo, "err", err)
+		} else if len(repotypes) == 1 {
+			if err := fetchRepo(repo, repotypes[0], q); err != nil {
+				slog.Error("failed to enqueue repo fetch", "repo", repo, "err", err)
+			}
+		}
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+const (
+	// redirectsFile is a Netlify-style file with redirect and rewrite rules at the root of a site
+	redirectsFile = "_redirects"
+	// headersFile is a Netlify-style file with custom response headers at the root of a site
+	headersFile = "_headers"
+	// cnameFile is a GitHub-style file with the custom domain of a site
+	cnameFile = "CNAME"
+)
+
+// configFiles are the files parsed by siteConfig
+var configFiles = map[string]bool{
+	redirectsFile: true,
+	headersFile:   true,
+	cnameFile:     true,
+	aliasesFile:   true,
+}
+
+// siteConfig parses configuration files shipped at the root of a site
+func siteConfig(files types.Pages, db *database.Database) (types.PagesConfig, error) {
+	var config types.PagesConfig
+	for _, file := range files {
+		if !configFiles[file.Name] {
+			continue
+		}
+		data, ok, err := db.PagesData().Get(file.SHA)
+		if err != nil || !ok {
+			return config, fmt.Errorf("failed to get %s: %w", file.Name, err)
+		}
+		switch file.Name {
+		case redirectsFile:
+			config.Redirects = parseRedirects(data)
+			slog.Info("found redirects", "rules", len(config.Redirects))
+		case headersFile:
+			config.Headers = parseHeaders(data)
+			slog.Info("found headers", "rules", len(config.Headers))
+		case aliasesFile:
+			config.Aliases = parseAliases(data)
+			slog.Info("found aliases", "aliases", len(config.Aliases))
+		case cnameFile:
+			config.CNAME = parseCNAME(data)
+			slog.Info("found custom domain", "domain", config.CNAME)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+// ownerCtxKey keeps the owner of a wildcard subdomain
+type ownerCtxKey struct{}
+
+func subdomainOwnerFromContext(ctx context.Context) (string, bool) {
+	owner, ok := ctx.Value(ownerCtxKey{}).(string)
+	return owner, ok
+}
+
+// subdomainOwner returns the owner for a host name under the wildcard domain
+func subdomainOwner(pages PagesInfo, host string) (string, bool) {
+	if pages.WildcardDomain == "" {
+		return "", false
+	}
+	owner, ok := strings.CutSuffix(host, "."+strings.ToLower(pages.WildcardDomain))
+	if !ok || owner == "" || strings.Contains(owner, ".") {
+		return "", false
+	}
+	return owner, true
+}
+
+// rootRepo returns the name of the repository served at the root of the owner subdomain
+func (p PagesInfo) rootRepo(owner string) string {
+	return strings.ReplaceAll(p.RootRepo, "{owner}", owner)
+}
+
+// subdomainPageRequest parses /{repo}[@version]/{path} of an owner subdomain, paths which do not
+// start with a repository with a pages- topic belong to the root repository of the owner
+func subdomainPageRequest(pages PagesInfo) pageRequestParser {
+	return func(r *http.Request, c *gitea.Client) (pageRequest, error) {
+		owner, _ := subdomainOwnerFromContext(r.Context())
+		sitePath := strings.TrimPrefix(r.URL.Path, "/")
+		segment, rest, _ := strings.Cut(sitePath, "/")
+		if segment != "" && !strings.HasPrefix(segment, "@") {
+			repoName, version, _ := strings.Cut(segment, "@")
+			repotypes, err := repoPagesTypes(r.Context(), c, owner, repoName)
+			if err != nil && giteaErrorStatus(err) != http.StatusNotFound {
+				return pageRequest{}, err
+			}
+			if err == nil && len(repotypes) != 0 {
+				return pageRequest{
+					Repo:    types.Repo{Owner: owner, Repo: repoName},
+					Version: version,
+					Path:    rest,
+					Base:    "/" + segment + "/",
+				}, nil
+			}
+		}
+		return rootPageRequest(types.Repo{Owner: owner, Repo: pages.rootRepo(owner)}, sitePath), nil
+	}
+}
@@ -4,9 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"slices"
 	"strings"
-	"time"
 
 	"code.gitea.io/sdk/gitea"
 	"github.com/ASMfreaK/pages-server/pages-server/consts"
@@ -43,18 +41,17 @@ func (f *FetchRepoFromBranches) ParseJob(s string) error {
 
 var _ database.TaskElement = (*FetchRepoFromBranches)(nil)
 
-func fetchRepoFromBranches(c *gitea.Client, db *database.Database) database.Task {
-	return database.FuncTask(func(ctx context.Context, task *FetchRepoFromBranches) error {
+func fetchRepoFromBranches(c *gitea.Client, rv *repoVersions) database.Task {
+	return database.FuncTask(func(ctx context.Context, task *FetchRepoFromBranches) (err error) {
 		slog.Info("fetching repo from branches", slog.String("owner", task.Owner), slog.String("repo", task.Repo))
-		repoInfo := types.RepoInfo{
-			Repo: types.Repo(*task),
-		}
+		progress := newFetchProgress(ctx, rv.db, types.Repo(*task), "", types.FetchPhaseListing)
+		defer func() { progress.Finish(err) }()
 		versions, err := allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]types.Version, *gitea.Response, error) {
 			branches, resp, err := c.ListRepoBranches(task.Owner, task.Repo, gitea.ListRepoBranchesOptions{
 				ListOptions: opts,
 			})
 			if err != nil {
-				err = fmt.Errorf("failed to list branches %w", err)
+				err = fmt.Errorf("failed to list branches %w", wrapGiteaError(resp, err))
 				return nil, nil, err
 			}
 			var ret []types.Version
@@ -64,8 +61,7 @@ func fetchRepoFromBranches(c *gitea.Client, db *database.Database) database.Task
 					SHA:       types.PagesSHA256FromString(branch.Commit.ID),
 				}
 				if branch.Name == consts.PagesBranch {
-					version.Version = "latest"
-					version.CreatedAt = time.Now()
+					version.Version = types.LatestVersion
 				} else {
 					version.Version = strings.TrimPrefix(branch.Name, consts.PagesBranchPrefix)
 					if version.Version == branch.Name {
@@ -79,28 +75,8 @@ func fetchRepoFromBranches(c *gitea.Client, db *database.Database) database.Task
 		if err != nil {
 			return err
 		}
-		if len(versions) == 0 {
-			slog.Error("no versions found for repo", "owner", task.Job())
-			return nil
-		}
-		repoInfo.Versions = versions
-		if len(repoInfo.Versions) != 0 {
-			slices.SortFunc(repoInfo.Versions, func(a, b types.Version) int {
-				return b.CreatedAt.Compare(a.CreatedAt)
-			})
-			repoInfo.Latest = repoInfo.Versions[0]
-		}
-		err = db.RepoPages().Set(types.Repo(*task), repoInfo)
-		if err != nil {
-			return err
-		}
-		q := database.QueueFromContext(ctx)
-		for _, v := range repoInfo.Versions {
-			err := q.Enqueue(ctx, &FetchVersionFromBranches{Repo: types.Repo(*task), Version: v})
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		return rv.Update(ctx, types.Repo(*task), versions, func(v types.Version) database.TaskElement {
+			return &FetchVersionFromBranches{Repo: types.Repo(*task), Version: v}
+		})
 	})
 }
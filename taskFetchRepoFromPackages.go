@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"slices"
 	"strings"
 
 	"code.gitea.io/sdk/gitea"
@@ -41,18 +40,17 @@ func (f *FetchRepoFromPackages) ParseJob(s string) error {
 
 var _ database.TaskElement = (*FetchRepoFromPackages)(nil)
 
-func fetchRepoFromPackages(c *gitea.Client, db *database.Database) database.Task {
-	return database.FuncTask(func(ctx context.Context, task *FetchRepoFromPackages) error {
+func fetchRepoFromPackages(c *gitea.Client, rv *repoVersions) database.Task {
+	return database.FuncTask(func(ctx context.Context, task *FetchRepoFromPackages) (err error) {
 		slog.Info("fetching repo from packages", slog.String("owner", task.Owner), slog.String("repo", task.Repo))
-		repoInfo := types.RepoInfo{
-			Repo: types.Repo(*task),
-		}
+		progress := newFetchProgress(ctx, rv.db, types.Repo(*task), "", types.FetchPhaseListing)
+		defer func() { progress.Finish(err) }()
 		versions, err := allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]types.Version, *gitea.Response, error) {
 			packages, resp, err := c.ListPackages(task.Owner, gitea.ListPackagesOptions{
 				ListOptions: opts,
 			})
 			if err != nil {
-				err = fmt.Errorf("failed to list packages %w", err)
+				err = fmt.Errorf("failed to list packages %w", wrapGiteaError(resp, err))
 				return nil, nil, err
 			}
 			var ret []types.Version
@@ -93,28 +91,8 @@ func fetchRepoFromPackages(c *gitea.Client, db *database.Database) database.Task
 		if err != nil {
 			return err
 		}
-		if len(versions) == 0 {
-			slog.Error("no versions found for repo", "owner", task.Job())
-			return nil
-		}
-		repoInfo.Versions = versions
-		if len(repoInfo.Versions) != 0 {
-			slices.SortFunc(repoInfo.Versions, func(a, b types.Version) int {
-				return b.CreatedAt.Compare(a.CreatedAt)
-			})
-			repoInfo.Latest = repoInfo.Versions[0]
-		}
-		err = db.RepoPages().Set(types.Repo(*task), repoInfo)
-		if err != nil {
-			return err
-		}
-		q := database.QueueFromContext(ctx)
-		for _, v := range repoInfo.Versions {
-			err := q.Enqueue(ctx, &FetchVersionFromPackages{Repo: types.Repo(*task), Version: v})
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		return rv.Update(ctx, types.Repo(*task), versions, func(v types.Version) database.TaskElement {
+			return &FetchVersionFromPackages{Repo: types.Repo(*task), Version: v}
+		})
 	})
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"slices"
 	"strconv"
 	"strings"
 
@@ -43,23 +42,23 @@ func (f *FetchRepoFromReleases) ParseJob(s string) error {
 
 var _ database.TaskElement = (*FetchRepoFromReleases)(nil)
 
-func fetchRepoFromReleases(c *gitea.Client, db *database.Database) database.Task {
-	return database.FuncTask(func(ctx context.Context, task *FetchRepoFromReleases) error {
+func fetchRepoFromReleases(c *gitea.Client, rv *repoVersions) database.Task {
+	return database.FuncTask(func(ctx context.Context, task *FetchRepoFromReleases) (err error) {
 		slog.Info("fetching repo from releases", slog.String("owner", task.Owner), slog.String("repo", task.Repo))
-		repoInfo := types.RepoInfo{
-			Repo: types.Repo(*task),
-		}
+		progress := newFetchProgress(ctx, rv.db, types.Repo(*task), "", types.FetchPhaseListing)
+		defer func() { progress.Finish(err) }()
 		versions, err := allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]types.Version, *gitea.Response, error) {
 			releases, resp, err := c.ListReleases(task.Owner, task.Repo, gitea.ListReleasesOptions{
 				ListOptions: opts,
 			})
 			if err != nil {
-				err = fmt.Errorf("failed to list releases %w", err)
+				err = fmt.Errorf("failed to list releases %w", wrapGiteaError(resp, err))
 				return nil, nil, err
 			}
 			var ret []types.Version
 			for _, release := range releases {
-				if release.IsPrerelease || release.IsDraft {
+				// prereleases are kept, the ordering policy decides whether they become the latest version
+				if release.IsDraft {
 					continue
 				}
 				files, err := allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]*gitea.Attachment, *gitea.Response, error) {
@@ -87,9 +86,11 @@ func fetchRepoFromReleases(c *gitea.Client, db *database.Database) database.Task
 				file := files[0]
 				kindaSha := types.PagesSHA256FromString(file.UUID)
 				ret = append(ret, types.Version{
-					Version:   release.TagName,
-					CreatedAt: release.CreatedAt,
-					SHA:       kindaSha,
+					Version:    release.TagName,
+					Title:      release.Title,
+					Prerelease: release.IsPrerelease,
+					CreatedAt:  release.CreatedAt,
+					SHA:        kindaSha,
 					Extra: map[string]any{
 						consts.ReleaseID:           strconv.FormatInt(release.ID, 10),
 						consts.ReleaseAttachmentID: strconv.FormatInt(file.ID, 10),
@@ -101,28 +102,8 @@ func fetchRepoFromReleases(c *gitea.Client, db *database.Database) database.Task
 		if err != nil {
 			return err
 		}
-		if len(versions) == 0 {
-			slog.Error("no versions found for repo", "owner", task.Job())
-			return nil
-		}
-		repoInfo.Versions = versions
-		if len(repoInfo.Versions) != 0 {
-			slices.SortFunc(repoInfo.Versions, func(a, b types.Version) int {
-				return b.CreatedAt.Compare(a.CreatedAt)
-			})
-			repoInfo.Latest = repoInfo.Versions[0]
-		}
-		err = db.RepoPages().Set(types.Repo(*task), repoInfo)
-		if err != nil {
-			return err
-		}
-		q := database.QueueFromContext(ctx)
-		for _, v := range repoInfo.Versions {
-			err := q.Enqueue(ctx, &FetchVersionFromReleases{Repo: types.Repo(*task), Version: v})
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		return rv.Update(ctx, types.Repo(*task), versions, func(v types.Version) database.TaskElement {
+			return &FetchVersionFromReleases{Repo: types.Repo(*task), Version: v}
+		})
 	})
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+func TestFetchRepoFromReleases(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	releases := []*gitea.Release{
+		{ID: 1, TagName: "v1.0.0", CreatedAt: created},
+		{ID: 2, TagName: "v1.1.0-rc1", IsPrerelease: true, CreatedAt: created.Add(time.Hour)},
+		{ID: 3, TagName: "v1.2.0", IsDraft: true, CreatedAt: created.Add(2 * time.Hour)},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/releases", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(releases)
+	})
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/releases/{id}/assets", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]*gitea.Attachment{{ID: 10, Name: "docs.zip", UUID: "uuid-" + r.PathValue("id")}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := gitea.NewClient(srv.URL, gitea.SetGiteaVersion(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ordering types.OrderingPolicy
+		latest   string
+	}{
+		{name: "stable latest", ordering: types.OrderingPolicy{Strategy: types.VersionOrderingSemver}, latest: "v1.0.0"},
+		{name: "prerelease latest", ordering: types.OrderingPolicy{Strategy: types.VersionOrderingSemver, Prereleases: true}, latest: "v1.1.0-rc1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := database.New(database.Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			fetched := make(chan string, len(releases))
+			q, err := database.NewQueue(context.Background(), db,
+				fetchRepoFromReleases(c, newRepoVersions(db, types.RetentionPolicy{}, tt.ordering)),
+				database.FuncTask(func(_ context.Context, task *FetchVersionFromReleases) error {
+					fetched <- task.Version.Version
+					return nil
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+			repo := types.Repo{Owner: "owner", Repo: "repo"}
+			if err := q.Enqueue(context.Background(), (*FetchRepoFromReleases)(&repo)); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for range 2 {
+				select {
+				case v := <-fetched:
+					got = append(got, v)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("fetched %v, timed out waiting for more versions", got)
+				}
+			}
+			slices.Sort(got)
+			if want := []string{"v1.0.0", "v1.1.0-rc1"}; !slices.Equal(got, want) {
+				t.Errorf("fetched %v, want %v", got, want)
+			}
+			repoInfo, ok, err := db.RepoPages().Get(repo)
+			if err != nil || !ok {
+				t.Fatalf("repo info = %v, %v", ok, err)
+			}
+			if repoInfo.Latest.Version != tt.latest {
+				t.Errorf("latest = %s, want %s", repoInfo.Latest.Version, tt.latest)
+			}
+			if next, ok := repoInfo.ResolveAlias(types.NextAlias, nil); !ok || next.Version != "v1.1.0-rc1" {
+				t.Errorf("next = %s, %v, want v1.1.0-rc1", next.Version, ok)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -60,7 +61,7 @@ func (f *FetchVersionFromPackages) ParseJob(s string) error {
 var _ database.TaskElement = (*FetchVersionFromPackages)(nil)
 
 func fetchVersionFromPackages(g GiteaInfo, db *database.Database) database.Task {
-	return database.FuncTask(func(ctx context.Context, task *FetchVersionFromPackages) error {
+	return database.FuncTask(func(ctx context.Context, task *FetchVersionFromPackages) (err error) {
 		slog.Info("fetching version from packages", "version", task)
 		{
 			_, ok, err := db.PagesMetadata().Get(task.Version.SHA)
@@ -72,6 +73,8 @@ func fetchVersionFromPackages(g GiteaInfo, db *database.Database) database.Task
 				return nil
 			}
 		}
+		progress := newFetchProgress(ctx, db, task.Repo, task.Version.Version, types.FetchPhaseDownloading)
+		defer func() { progress.Finish(err) }()
 		url := fmt.Sprintf(
 			"%s/api/packages/%s/generic/%s/%s/%s",
 			strings.TrimSuffix(g.URL, "/"),
@@ -91,7 +94,7 @@ func fetchVersionFromPackages(g GiteaInfo, db *database.Database) database.Task
 		}
 		defer rsp.Body.Close()
 		if rsp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to fetch docs.zip from %s %s", url, rsp.Status)
+			return &giteaError{status: rsp.StatusCode, err: fmt.Errorf("failed to fetch docs.zip from %s %s", url, rsp.Status)}
 		}
 		f, err := os.CreateTemp("", "tmpfile-")
 		if err != nil {
@@ -104,14 +107,14 @@ func fetchVersionFromPackages(g GiteaInfo, db *database.Database) database.Task
 
 		fb := bufio.NewWriter(f)
 		slog.Info("writing to a temp file", "name", f.Name())
-		written, err := io.Copy(fb, rsp.Body)
+		written, err := io.Copy(fb, progress.Download(rsp.Body, rsp.ContentLength))
 		if err != nil {
 			slog.Info("error writing to a temp file", "name", f.Name(), "err", err)
 			return err
 		}
 		slog.Info("done writing to a temp file", "name", f.Name())
 		if written != rsp.ContentLength {
-			return fmt.Errorf("failed to write all data")
+			return errIncompleteDownload
 		}
 		err = fb.Flush()
 		if err != nil {
@@ -124,27 +127,20 @@ func fetchVersionFromPackages(g GiteaInfo, db *database.Database) database.Task
 			err = fmt.Errorf("failed to seek: %w", err)
 			return err
 		}
+		progress.Phase(types.FetchPhaseVerifying)
 		hash, err := types.HashPagesFile(bufio.NewReader(f))
 		if err != nil {
 			return err
 		}
 		if hash != task.Version.SHA {
-			return fmt.Errorf("sha256 mismatch")
+			return errChecksumMismatch
 		}
 		_, err = f.Seek(0, 0)
 		if err != nil {
 			err = fmt.Errorf("failed to seek: %w", err)
 			return err
 		}
-		files, err := unzipDocs(ctx, f, written, db)
-		if err != nil {
-			return err
-		}
-		err = db.PagesMetadata().Set(task.Version.SHA, files)
-		if err != nil {
-			return err
-		}
-		return nil
+		return storeDocs(ctx, f, written, task.Repo, task.Version.SHA, db, unzipProgress(progress))
 	})
 }
 
@@ -190,7 +186,7 @@ func (f *FetchVersionFromReleases) ParseJob(s string) error {
 var _ database.TaskElement = (*FetchVersionFromReleases)(nil)
 
 func fetchVersionFromReleases(c *gitea.Client, g GiteaInfo, db *database.Database) database.Task {
-	return database.FuncTask(func(ctx context.Context, task *FetchVersionFromReleases) error {
+	return database.FuncTask(func(ctx context.Context, task *FetchVersionFromReleases) (err error) {
 		slog.Info("fetching version from packages", "version", task)
 		{
 			_, ok, err := db.PagesMetadata().Get(task.Version.SHA)
@@ -202,6 +198,8 @@ func fetchVersionFromReleases(c *gitea.Client, g GiteaInfo, db *database.Databas
 				return nil
 			}
 		}
+		progress := newFetchProgress(ctx, db, task.Repo, task.Version.Version, types.FetchPhaseDownloading)
+		defer func() { progress.Finish(err) }()
 		releaseID, err := strconv.ParseInt(task.Version.Extra[consts.ReleaseID].(string), 10, 64)
 		if err != nil {
 			return fmt.Errorf("failed to get release id: %w", err)
@@ -210,9 +208,9 @@ func fetchVersionFromReleases(c *gitea.Client, g GiteaInfo, db *database.Databas
 		if err != nil {
 			return fmt.Errorf("failed to get release id: %w", err)
 		}
-		a, _, err := c.GetReleaseAttachment(task.Repo.Owner, task.Repo.Repo, releaseID, attachmentID)
+		a, resp, err := c.GetReleaseAttachment(task.Repo.Owner, task.Repo.Repo, releaseID, attachmentID)
 		if err != nil {
-			return err
+			return wrapGiteaError(resp, err)
 		}
 		rq, err := http.NewRequest("GET", a.DownloadURL, nil)
 		if err != nil {
@@ -225,7 +223,7 @@ func fetchVersionFromReleases(c *gitea.Client, g GiteaInfo, db *database.Databas
 		}
 		defer rsp.Body.Close()
 		if rsp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to fetch docs.zip from %s %s", a.DownloadURL, rsp.Status)
+			return &giteaError{status: rsp.StatusCode, err: fmt.Errorf("failed to fetch docs.zip from %s %s", a.DownloadURL, rsp.Status)}
 		}
 		f, err := os.CreateTemp("", "tmpfile-")
 		if err != nil {
@@ -238,14 +236,14 @@ func fetchVersionFromReleases(c *gitea.Client, g GiteaInfo, db *database.Databas
 
 		fb := bufio.NewWriter(f)
 		slog.Info("writing to a temp file", "name", f.Name())
-		written, err := io.Copy(fb, rsp.Body)
+		written, err := io.Copy(fb, progress.Download(rsp.Body, rsp.ContentLength))
 		if err != nil {
 			slog.Info("error writing to a temp file", "name", f.Name(), "err", err)
 			return err
 		}
 		slog.Info("done writing to a temp file", "name", f.Name())
 		if written != rsp.ContentLength {
-			return fmt.Errorf("failed to write all data")
+			return errIncompleteDownload
 		}
 		err = fb.Flush()
 		if err != nil {
@@ -268,15 +266,7 @@ func fetchVersionFromReleases(c *gitea.Client, g GiteaInfo, db *database.Databas
 			err = fmt.Errorf("failed to seek: %w", err)
 			return err
 		}
-		files, err := unzipDocs(ctx, f, written, db)
-		if err != nil {
-			return err
-		}
-		err = db.PagesMetadata().Set(task.Version.SHA, files)
-		if err != nil {
-			return err
-		}
-		return nil
+		return storeDocs(ctx, f, written, task.Repo, task.Version.SHA, db, unzipProgress(progress))
 	})
 }
 
@@ -317,7 +307,7 @@ func (f *FetchVersionFromBranches) ParseJob(s string) error {
 var _ database.TaskElement = (*FetchVersionFromBranches)(nil)
 
 func fetchVersionFromBranches(c *gitea.Client, db *database.Database) database.Task {
-	return database.FuncTask(func(ctx context.Context, task *FetchVersionFromBranches) error {
+	return database.FuncTask(func(ctx context.Context, task *FetchVersionFromBranches) (err error) {
 		slog.Info("fetching version from packages", "version", task)
 		{
 			_, ok, err := db.PagesMetadata().Get(task.Version.SHA)
@@ -329,9 +319,11 @@ func fetchVersionFromBranches(c *gitea.Client, db *database.Database) database.T
 				return nil
 			}
 		}
-		data, _, err := c.GetArchive(task.Repo.Owner, task.Repo.Repo, string(task.Version.SHA), gitea.ZipArchive)
+		progress := newFetchProgress(ctx, db, task.Repo, task.Version.Version, types.FetchPhaseDownloading)
+		defer func() { progress.Finish(err) }()
+		data, resp, err := c.GetArchive(task.Repo.Owner, task.Repo.Repo, string(task.Version.SHA), gitea.ZipArchive)
 		if err != nil {
-			return err
+			return wrapGiteaError(resp, err)
 		}
 		f, err := os.CreateTemp("", "tmpfile-")
 		if err != nil {
@@ -353,7 +345,7 @@ func fetchVersionFromBranches(c *gitea.Client, db *database.Database) database.T
 		}
 		slog.Info("done writing to a temp file", "name", f.Name())
 		if written != dataLen {
-			return fmt.Errorf("failed to write all data")
+			return errIncompleteDownload
 		}
 		err = fb.Flush()
 		if err != nil {
@@ -372,20 +364,36 @@ func fetchVersionFromBranches(c *gitea.Client, db *database.Database) database.T
 			err = fmt.Errorf("failed to seek: %w", err)
 			return err
 		}
-		files, err := unzipDocs(ctx, f, written, db, unzipStripComponents(1))
+		return storeDocs(ctx, f, written, task.Repo, task.Version.SHA, db, unzipStripComponents(1), unzipProgress(progress))
+	})
+}
+
+// storeDocs unzips the archive and stores its pages along with the site configuration,
+// then drops served versions it replaces and registers the custom domain of the repo
+func storeDocs(ctx context.Context, f io.ReaderAt, fSize int64, repo types.Repo, sha types.PagesSHA256, db *database.Database, optFuncs ...unzipDocsOption) error {
+	err := db.StorePages(sha, func() (types.Pages, types.PagesConfig, error) {
+		files, err := unzipDocs(ctx, f, fSize, db, optFuncs...)
 		if err != nil {
-			return err
+			return nil, types.PagesConfig{}, err
 		}
-		err = db.PagesMetadata().Set(task.Version.SHA, files)
+		config, err := siteConfig(files, db)
 		if err != nil {
-			return err
+			return nil, types.PagesConfig{}, err
 		}
-		return nil
+		return files, config, nil
 	})
+	if err != nil {
+		return err
+	}
+	if err := refreshServedVersions(db, repo); err != nil {
+		return err
+	}
+	return syncCNAME(db, repo)
 }
 
 type unzipDocsOptions struct {
 	stripComponents int
+	progress        *fetchProgress
 }
 
 type unzipDocsOption func(o *unzipDocsOptions)
@@ -396,6 +404,12 @@ func unzipStripComponents(s int) unzipDocsOption {
 	}
 }
 
+func unzipProgress(p *fetchProgress) unzipDocsOption {
+	return func(o *unzipDocsOptions) {
+		o.progress = p
+	}
+}
+
 func unzipDocs(ctx context.Context, f io.ReaderAt, fSize int64, db *database.Database, optFuncs ...unzipDocsOption) (types.Pages, error) {
 	var opts unzipDocsOptions
 	for _, f := range optFuncs {
@@ -405,7 +419,14 @@ func unzipDocs(ctx context.Context, f io.ReaderAt, fSize int64, db *database.Dat
 	if err != nil {
 		return nil, err
 	}
+	opts.progress.Unzip(len(zr.File))
+	names := make(map[string]struct{}, len(zr.File))
+	for _, file := range zr.File {
+		names[file.Name] = struct{}{}
+	}
 	var files types.Pages
+	// fileIdx maps names in the archive to files, it is used to attach shipped precompressed siblings
+	fileIdx := make(map[string]int, len(zr.File))
 	var filesMux sync.Mutex
 	eg, _ := errgroup.WithContext(ctx)
 	eg.SetLimit(5)
@@ -416,6 +437,7 @@ func unzipDocs(ctx context.Context, f io.ReaderAt, fSize int64, db *database.Dat
 				return ferr
 			}
 			defer f.Close()
+			defer opts.progress.FileUnzipped()
 			var buf bytes.Buffer
 			_, ferr = io.Copy(&buf, f)
 			if ferr != nil {
@@ -428,6 +450,10 @@ func unzipDocs(ctx context.Context, f io.ReaderAt, fSize int64, db *database.Dat
 				ferr = fmt.Errorf("failed to set page data: %w", ferr)
 				return ferr
 			}
+			encodings, ferr := storeCompressedVariants(db, file.Name, data, names)
+			if ferr != nil {
+				return ferr
+			}
 
 			filesMux.Lock()
 			defer filesMux.Unlock()
@@ -450,9 +476,11 @@ func unzipDocs(ctx context.Context, f io.ReaderAt, fSize int64, db *database.Dat
 				saveName = saveName[idx+1:]
 			}
 			slog.Info("found file", "name", file.Name, "hash", hash, "saveName", saveName)
+			fileIdx[file.Name] = len(files)
 			files = append(files, types.PageFile{
-				Name: saveName,
-				SHA:  hash,
+				Name:      saveName,
+				SHA:       hash,
+				Encodings: encodings,
 			})
 			return nil
 		})
@@ -461,5 +489,50 @@ func unzipDocs(ctx context.Context, f io.ReaderAt, fSize int64, db *database.Dat
 	if err != nil {
 		return nil, err
 	}
+	for name, i := range fileIdx {
+		encoding, ok := encodingExtensions[path.Ext(name)]
+		if !ok {
+			continue
+		}
+		j, ok := fileIdx[strings.TrimSuffix(name, path.Ext(name))]
+		if !ok {
+			continue
+		}
+		if files[j].Encodings == nil {
+			files[j].Encodings = make(map[string]types.PageSHA256)
+		}
+		files[j].Encodings[encoding] = files[i].SHA
+	}
 	return files, nil
 }
+
+// storeCompressedVariants stores gzip and brotli variants of a compressible file
+// unless the archive ships them as siblings, variants which do not save space are dropped
+func storeCompressedVariants(db *database.Database, name string, data []byte, names map[string]struct{}) (map[string]types.PageSHA256, error) {
+	if len(data) < minCompressSize || !compressible(name) {
+		return nil, nil
+	}
+	var encodings map[string]types.PageSHA256
+	for ext, encoding := range encodingExtensions {
+		if _, shipped := names[name+ext]; shipped {
+			continue
+		}
+		compressed, err := compress(encoding, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s with %s: %w", name, encoding, err)
+		}
+		// compressed responses must be worth the decompression on the client
+		if len(compressed) > len(data)*9/10 {
+			continue
+		}
+		hash := types.HashPage(compressed)
+		if err := db.PagesData().Set(hash, compressed); err != nil {
+			return nil, fmt.Errorf("failed to set page data: %w", err)
+		}
+		if encodings == nil {
+			encodings = make(map[string]types.PageSHA256)
+		}
+		encodings[encoding] = hash
+	}
+	return encodings, nil
+}
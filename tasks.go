@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/ASMfreaK/pages-server/pages-server/database"
 	"github.com/ASMfreaK/pages-server/pages-server/types"
@@ -15,64 +16,259 @@ var (
 	ErrFileNotFound    = errors.New("file not found")
 )
 
-func requestPageData(r *types.RepoFileAtVersion, rt types.RepoType, db *database.Database, q *database.Queue) (data []byte, fetched bool, err error) {
-	slog.Info("requesting page data", "repo", r)
-	repoInfo, ok, err := db.RepoPages().Get(r.Repo)
+// pageData is a file of a repository version ready to be served
+type pageData struct {
+	Data    []byte
+	File    types.PageFile
+	Version types.Version
+	// Encoding is the content coding of Data, empty for the file as is
+	Encoding string
+	// SHA is the hash of Data
+	SHA types.PageSHA256
+}
+
+// siteVersion is a fetched version of a repository site with its pages and configuration
+type siteVersion struct {
+	Repo    types.Repo
+	Version types.Version
+	Pages   types.Pages
+	Config  types.PagesConfig
+	// Alias is the requested alias which resolved to Version
+	Alias string
+}
+
+// requestSiteVersion finds the version of the repository site, the latest one for an empty versionName.
+// Names which are not versions are resolved as aliases.
+// Missing repository info and pages are enqueued for fetching and reported with fetched == false,
+// with a nil q or after a failed fetch which is not retried they are only reported.
+func requestSiteVersion(repo types.Repo, versionName string, rt types.RepoType, db *database.Database, q *database.Queue) (site siteVersion, fetched bool, err error) {
+	slog.Info("requesting site version", "repo", repo, "version", versionName)
+	site.Repo = repo
+	repoInfo, ok, err := db.RepoPages().Get(repo)
 	if err != nil {
 		err = fmt.Errorf("failed to get repo info %w", err)
-		return nil, false, err
+		return site, false, err
 	}
 	if !ok {
-		err = fetchRepo(r.Repo, rt, q)
+		if q == nil || fetchFailed(db, repo, "") {
+			return site, false, nil
+		}
+		err = fetchRepo(repo, rt, q)
 		if err != nil {
 			slog.Error("failed to enqueue fetch repo", "err", err)
 		}
-		return nil, false, nil
-	}
-	var version types.Version
-	if r.Version == "" {
-		version = repoInfo.Latest
-	} else {
-		fetched = false
-		for _, v := range repoInfo.Versions {
-			if v.Version == r.Version {
-				version = v
-				fetched = true
-				break
-			}
-		}
-		if !fetched {
-			return nil, false, ErrVersionNotFound
+		return site, false, nil
+	}
+	site.Version, site.Alias, err = resolveVersion(db, repoInfo, versionName)
+	if err != nil {
+		return site, false, err
+	}
+	if site.Version.SHA == "" {
+		return site, false, nil
+	}
+	site.Pages, ok, err = db.PagesMetadata().Get(site.Version.SHA)
+	if err != nil {
+		return site, false, err
+	}
+	if !ok {
+		if q == nil || fetchFailed(db, repo, site.Version.Version) {
+			return site, false, nil
 		}
+		err = fetchVersion(repo, site.Version, rt, q)
+		return site, false, err
 	}
-	if version.SHA == "" {
-		return nil, false, nil
+	// versions fetched before configuration files were supported have none
+	site.Config, _, err = db.PagesConfig().Get(site.Version.SHA)
+	if err != nil {
+		return site, false, fmt.Errorf("failed to get pages config %w", err)
 	}
-	pages, ok, err := db.PagesMetadata().Get(version.SHA)
+	return site, true, nil
+}
+
+// resolveVersion finds the version of the repository requested with versionName, the latest one for
+// an empty versionName. Names which are not versions are resolved as aliases and returned as alias.
+func resolveVersion(db *database.Database, repoInfo types.RepoInfo, versionName string) (v types.Version, alias string, err error) {
+	if versionName == "" {
+		return repoInfo.Latest, "", nil
+	}
+	if v, ok := repoInfo.Version(versionName); ok {
+		return v, "", nil
+	}
+	// the alias map is published with the latest version
+	latestConfig, _, err := db.PagesConfig().Get(repoInfo.Latest.SHA)
 	if err != nil {
-		return nil, false, err
+		return v, "", fmt.Errorf("failed to get pages config %w", err)
 	}
+	v, ok := repoInfo.ResolveAlias(versionName, latestConfig.Aliases)
 	if !ok {
-		err = fetchVersion(r.Repo, version, rt, q)
-		return nil, false, err
+		return v, "", ErrVersionNotFound
+	}
+	return v, versionName, nil
+}
+
+// fetchFailed reports whether the last fetch of the version failed and is not retried yet
+func fetchFailed(db *database.Database, repo types.Repo, versionName string) bool {
+	_, failed, err := activeFetchFailure(db, repo, versionName)
+	if err != nil {
+		slog.Error("failed to get fetch failure", "repo", repo, "version", versionName, "err", err)
+	}
+	return failed
+}
+
+// repoVersionKey identifies a version name of a repository, an empty name is the latest version
+func repoVersionKey(repo types.Repo, versionName string) string {
+	return repo.String() + "@" + versionName
+}
+
+// servedVersionKey is the key of the version served for versionName: the latest version or the concrete
+// version the name resolved to, aliases and prefixes share the record of their version
+func servedVersionKey(site siteVersion, versionName string) (string, bool) {
+	if versionName == "" {
+		return repoVersionKey(site.Repo, ""), true
+	}
+	if site.Version.Version == "" {
+		return "", false
 	}
+	return repoVersionKey(site.Repo, site.Version.Version), true
+}
 
-	if r.File == "" {
-		r.File = "index.html"
+// rememberSiteVersion records the version served for versionName, it is served while a newer version is fetched
+func rememberSiteVersion(db *database.Database, site siteVersion, versionName string) error {
+	key, ok := servedVersionKey(site, versionName)
+	if !ok {
+		return nil
+	}
+	served, ok, err := db.ServedVersions().Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to get served version %w", err)
 	}
-	if strings.HasSuffix(r.File, "/") {
-		r.File += "index.html"
+	if ok && served.Version.SHA == site.Version.SHA && served.StaleSince.IsZero() {
+		return nil
 	}
-	var fileSha types.PageSHA256
-	for _, file := range pages {
-		if file.Name == r.File {
-			fileSha = file.SHA
-			break
+	return db.ServedVersions().Set(key, types.ServedVersion{Version: site.Version})
+}
+
+// forgetSiteVersion removes the version served for versionName once the name is gone
+func forgetSiteVersion(db *database.Database, repo types.Repo, versionName string) error {
+	return db.ServedVersions().Delete(repoVersionKey(repo, versionName))
+}
+
+// refreshServedVersions updates served versions of the repository after its versions or pages changed:
+// records whose name resolves to fetched pages again are dropped, the others become stale once their
+// name resolves to another version and expire MaxStaleness later
+func refreshServedVersions(db *database.Database, repo types.Repo) error {
+	repoInfo, ok, err := db.RepoPages().Get(repo)
+	if err != nil || !ok {
+		return err
+	}
+	prefix := repoVersionKey(repo, "")
+	records := map[string]types.ServedVersion{}
+	err = db.ServedVersions().ForEach(func(k string, served types.ServedVersion) error {
+		if strings.HasPrefix(k, prefix) {
+			records[k] = served
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list served versions %w", err)
+	}
+	now := time.Now()
+	var drop []string
+	for k, served := range records {
+		current, ok := repoInfo.Latest, true
+		if name := strings.TrimPrefix(k, prefix); name != "" {
+			current, ok = repoInfo.Version(name)
+		}
+		if ok && current.SHA == served.Version.SHA {
+			continue
+		}
+		if ok && current.SHA != "" {
+			_, fetched, err := db.PagesMetadata().Get(current.SHA)
+			if err != nil {
+				return fmt.Errorf("failed to get pages metadata %w", err)
+			}
+			if fetched {
+				drop = append(drop, k)
+				continue
+			}
+		}
+		if served.StaleSince.IsZero() {
+			served.StaleSince = now
+			if err := db.ServedVersions().Set(k, served); err != nil {
+				return fmt.Errorf("failed to set served version %w", err)
+			}
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+	slog.Info("dropping served versions, newer versions are fetched", "repo", repo, "keys", drop)
+	return db.ServedVersions().DeleteAll(drop)
+}
+
+// staleSiteVersion finds the version last served for versionName while the requested version is fetched.
+// It is served for at most maxStaleness after it became stale, 0 disables stale versions.
+func staleSiteVersion(db *database.Database, site siteVersion, versionName string, maxStaleness time.Duration) (siteVersion, bool, error) {
+	key, ok := servedVersionKey(site, versionName)
+	if maxStaleness <= 0 || !ok {
+		return site, false, nil
+	}
+	served, ok, err := db.ServedVersions().Get(key)
+	if err != nil || !ok {
+		return site, false, err
+	}
+	now := time.Now()
+	if served.StaleSince.IsZero() {
+		served.StaleSince = now
+		if err := db.ServedVersions().Set(key, served); err != nil {
+			return site, false, fmt.Errorf("failed to set served version %w", err)
 		}
+	} else if now.Sub(served.StaleSince) > maxStaleness {
+		slog.Info("served version is too stale", "repo", site.Repo, "version", served.Version.Version, "stale_since", served.StaleSince)
+		return site, false, nil
+	}
+	stale := siteVersion{Repo: site.Repo, Version: served.Version, Alias: site.Alias}
+	stale.Pages, ok, err = db.PagesMetadata().Get(served.Version.SHA)
+	if err != nil || !ok {
+		return site, false, err
+	}
+	stale.Config, _, err = db.PagesConfig().Get(served.Version.SHA)
+	if err != nil {
+		return site, false, fmt.Errorf("failed to get pages config %w", err)
 	}
-	if fileSha == "" {
-		return nil, false, ErrFileNotFound
+	return stale, true, nil
+}
+
+// file finds a file of the site, directories resolve to their index.html
+func (s siteVersion) file(name string) (types.PageFile, bool) {
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	for _, file := range s.Pages {
+		if file.Name == name {
+			return file, true
+		}
 	}
+	return types.PageFile{}, false
+}
 
-	return db.PagesData().Get(fileSha)
+// page loads the file variant best matching acceptEncoding
+func (s siteVersion) page(name, acceptEncoding string, db *database.Database) (page pageData, fetched bool, err error) {
+	page.Version = s.Version
+	page.File, fetched = s.file(name)
+	if !fetched {
+		return page, false, ErrFileNotFound
+	}
+	if encoding := chooseEncoding(acceptEncoding, page.File.Encodings); encoding != "" {
+		sha := page.File.Encodings[encoding]
+		page.Data, fetched, err = db.PagesData().Get(sha)
+		if err == nil && fetched {
+			page.Encoding, page.SHA = encoding, sha
+			return page, fetched, nil
+		}
+		slog.Warn("compressed variant is missing, serving the file as is", "file", page.File.Name, "encoding", encoding, "err", err)
+	}
+	page.SHA = page.File.SHA
+	page.Data, fetched, err = db.PagesData().Get(page.SHA)
+	return page, fetched, err
 }
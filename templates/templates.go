@@ -21,6 +21,7 @@ func compileTemplate(name, data string) (*template.Template, error) {
 	})
 	tmpl := template.New(name).Funcs(template.FuncMap{
 		"IndexJSFileName": IndexJSFileName,
+		"dict":            dict,
 	})
 	ms, err := m.String("text/html", data)
 	if err != nil {
@@ -29,6 +30,22 @@ func compileTemplate(name, data string) (*template.Template, error) {
 	return tmpl.Parse(ms)
 }
 
+// dict builds a map from key-value pairs to pass several values to a nested template
+func dict(kv ...any) (map[string]any, error) {
+	if len(kv)%2 != 0 {
+		return nil, fmt.Errorf("dict expects key-value pairs, got %d arguments", len(kv))
+	}
+	ret := make(map[string]any, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, ok := kv[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", kv[i])
+		}
+		ret[k] = kv[i+1]
+	}
+	return ret, nil
+}
+
 //go:embed index.js
 var IndexJS []byte
 
@@ -51,3 +68,15 @@ var Preparation = template.Must(compileTemplate("preparation", preparation))
 //go:embed error.html
 var errorPageText string
 var Error = template.Must(compileTemplate("error", errorPageText))
+
+//go:embed fetchFailed.html
+var fetchFailed string
+var FetchFailed = template.Must(compileTemplate("fetchFailed", fetchFailed))
+
+//go:embed queues.html
+var queues string
+var Queues = template.Must(compileTemplate("queues", queues))
+
+//go:embed pin.html
+var pin string
+var Pin = template.Must(compileTemplate("pin", pin))
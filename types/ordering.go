@@ -0,0 +1,249 @@
+package types
+
+//go:generate go-enum --marshal --names --values
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// LatestVersion is the name of the version published without a version, e.g. from the gh-pages branch.
+// A version with this name is always the latest one.
+const LatestVersion = "latest"
+
+// ENUM(semver,calendar,date)
+type VersionOrdering int
+
+// OrderingPolicy orders versions of a repository and selects the latest one.
+// With semver and calendar orderings versions which do not parse go after the ones which do,
+// newest first.
+type OrderingPolicy struct {
+	Strategy VersionOrdering `json:"strategy"`
+	// Prereleases lets prerelease versions become the latest version
+	Prereleases bool `json:"prereleases,omitempty"`
+}
+
+func (p OrderingPolicy) Validate() error {
+	if !p.Strategy.IsValid() {
+		return fmt.Errorf("invalid strategy %s, expected one of %v", p.Strategy, VersionOrderingNames())
+	}
+	return nil
+}
+
+// calendarVersion matches calendar versions like 2024.05, 24.04.1 or 2024-05-01, anything after
+// the numbers marks a prerelease
+var calendarVersion = regexp.MustCompile(`^v?(\d+)((?:[.\-_]\d+)*)(.*)$`)
+
+var calendarSegment = regexp.MustCompile(`\d+`)
+
+// versionKey is a parsed version name
+type versionKey struct {
+	version    Version
+	parsed     bool
+	segments   []int64
+	semver     *version.Version
+	prerelease bool
+}
+
+func (p OrderingPolicy) key(v Version) versionKey {
+	k := versionKey{version: v, prerelease: v.Prerelease}
+	switch p.Strategy {
+	case VersionOrderingSemver:
+		sv, err := version.NewSemver(v.Version)
+		if err != nil {
+			return k
+		}
+		k.parsed, k.semver = true, sv
+		k.prerelease = k.prerelease || sv.Prerelease() != ""
+	case VersionOrderingCalendar:
+		m := calendarVersion.FindStringSubmatch(v.Version)
+		if m == nil {
+			return k
+		}
+		for _, s := range calendarSegment.FindAllString(m[1]+m[2], -1) {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return k
+			}
+			k.segments = append(k.segments, n)
+		}
+		k.parsed = true
+		k.prerelease = k.prerelease || m[3] != ""
+	case VersionOrderingDate:
+		// versions are ordered by creation only, names still mark prereleases like 2.0.0-rc1
+		if sv, err := version.NewSemver(v.Version); err == nil {
+			k.prerelease = k.prerelease || sv.Prerelease() != ""
+		}
+	}
+	return k
+}
+
+// compare orders keys highest first
+func (a versionKey) compare(b versionKey) int {
+	switch {
+	case a.parsed && !b.parsed:
+		return -1
+	case !a.parsed && b.parsed:
+		return 1
+	case a.parsed && a.semver != nil:
+		if c := b.semver.Compare(a.semver); c != 0 {
+			return c
+		}
+	case a.parsed:
+		if c := slices.Compare(b.segments, a.segments); c != 0 {
+			return c
+		}
+		// 2024.05 is released after 2024.05-rc1
+		if a.prerelease != b.prerelease {
+			if a.prerelease {
+				return 1
+			}
+			return -1
+		}
+	}
+	if c := b.version.CreatedAt.Compare(a.version.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.version.Version, a.version.Version)
+}
+
+// Sort orders versions highest first, marks prereleases and returns the latest version: the version named LatestVersion,
+// otherwise the highest version which is not a prerelease, unless prereleases are allowed
+func (p OrderingPolicy) Sort(versions []Version) Version {
+	keys := make([]versionKey, 0, len(versions))
+	for _, v := range versions {
+		keys = append(keys, p.key(v))
+	}
+	slices.SortStableFunc(keys, versionKey.compare)
+	for i, k := range keys {
+		// aliases resolve stable versions without the ordering
+		k.version.Prerelease = k.prerelease
+		versions[i] = k.version
+	}
+	if len(versions) == 0 {
+		return Version{}
+	}
+	for _, k := range keys {
+		if k.version.Version == LatestVersion {
+			return k.version
+		}
+	}
+	for _, k := range keys {
+		if p.Prereleases || !k.prerelease {
+			return k.version
+		}
+	}
+	return versions[0]
+}
+
+const (
+	// StableAlias is the highest version which is not a prerelease
+	StableAlias = "stable"
+	// NextAlias is the highest version including prereleases
+	NextAlias = "next"
+)
+
+var channelAlias = regexp.MustCompile(`^[a-z]+$`)
+
+// versionSegments parses the numbers and the prerelease suffix of a version name like the strategy does,
+// the date strategy parses names as semver
+func (p OrderingPolicy) versionSegments(name string) (segments []int64, prerelease string, ok bool) {
+	if p.Strategy == VersionOrderingCalendar {
+		m := calendarVersion.FindStringSubmatch(name)
+		if m == nil {
+			return nil, "", false
+		}
+		for _, s := range calendarSegment.FindAllString(m[1]+m[2], -1) {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, "", false
+			}
+			segments = append(segments, n)
+		}
+		return segments, strings.TrimLeft(m[3], "-._"), true
+	}
+	v, err := version.NewVersion(name)
+	if err != nil {
+		return nil, "", false
+	}
+	// go-version pads segments to three, a prefix has as many as it names
+	n := len(strings.Split(strings.TrimPrefix(strings.SplitN(name, "-", 2)[0], "v"), "."))
+	return v.Segments64()[:min(n, len(v.Segments64()))], v.Prerelease(), true
+}
+
+// channelMatches tells if the prerelease suffix belongs to the channel: beta matches beta, beta2 and
+// beta.2, but not betamax
+func channelMatches(prerelease, channel string) bool {
+	rest, ok := strings.CutPrefix(prerelease, channel)
+	return ok && (rest == "" || rest[0] == '.' || rest[0] >= '0' && rest[0] <= '9')
+}
+
+// ResolveAlias finds the version an alias points to, in order: the alias map published with the site,
+// latest, stable and next, a version prefix like v2 or v2.3 matching the highest stable version,
+// a prerelease channel like beta or rc matching the first prerelease of the channel.
+// Versions must be sorted highest first by ri.Ordering, which also parses prefixes.
+func (ri RepoInfo) ResolveAlias(alias string, aliases map[string]string) (Version, bool) {
+	if target, ok := aliases[alias]; ok {
+		if v, ok := ri.Version(target); ok {
+			return v, true
+		}
+		// the alias map may point at other aliases, but not at itself
+		return ri.ResolveAlias(target, nil)
+	}
+	switch alias {
+	case LatestVersion:
+		return ri.Latest, ri.Latest.Version != ""
+	case StableAlias:
+		for _, v := range ri.Versions {
+			if !v.Prerelease {
+				return v, true
+			}
+		}
+		return Version{}, false
+	case NextAlias:
+		if len(ri.Versions) == 0 {
+			return Version{}, false
+		}
+		return ri.Versions[0], true
+	}
+	if prefix, prerelease, ok := ri.Ordering.versionSegments(alias); ok && prerelease == "" {
+		// the date strategy sorts by creation, the highest version is searched among all matching ones
+		var best Version
+		var bestSegments []int64
+		for _, v := range ri.Versions {
+			segments, _, ok := ri.Ordering.versionSegments(v.Version)
+			if !ok || v.Prerelease || len(segments) < len(prefix) || !slices.Equal(segments[:len(prefix)], prefix) {
+				continue
+			}
+			if bestSegments == nil || slices.Compare(segments, bestSegments) > 0 {
+				best, bestSegments = v, segments
+			}
+		}
+		return best, bestSegments != nil
+	}
+	if channelAlias.MatchString(alias) {
+		for _, v := range ri.Versions {
+			_, prerelease, ok := ri.Ordering.versionSegments(v.Version)
+			if ok && v.Prerelease && channelMatches(prerelease, alias) {
+				return v, true
+			}
+		}
+	}
+	return Version{}, false
+}
+
+// Version finds a version by its name
+func (ri RepoInfo) Version(name string) (Version, bool) {
+	for _, v := range ri.Versions {
+		if v.Version == name {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
@@ -0,0 +1,170 @@
+package types
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versions builds versions created a day apart in the given order, names ending in ! are Gitea prereleases
+func versions(names ...string) []Version {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ret := make([]Version, 0, len(names))
+	for i, name := range names {
+		v := Version{Version: name, CreatedAt: start.Add(time.Duration(i) * 24 * time.Hour)}
+		if n, ok := strings.CutSuffix(name, "!"); ok {
+			v.Version, v.Prerelease = n, true
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func names(vs []Version) []string {
+	ret := make([]string, 0, len(vs))
+	for _, v := range vs {
+		ret = append(ret, v.Version)
+	}
+	return ret
+}
+
+func TestOrderingPolicySort(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OrderingPolicy
+		in     []Version
+		sorted []string
+		latest string
+	}{
+		{
+			name:   "semver",
+			policy: OrderingPolicy{Strategy: VersionOrderingSemver},
+			in:     versions("v1.10.0", "v1.2.0", "v2.0.0-rc1", "main", "v1.9.3"),
+			sorted: []string{"v2.0.0-rc1", "v1.10.0", "v1.9.3", "v1.2.0", "main"},
+			latest: "v1.10.0",
+		},
+		{
+			name:   "semver with prereleases",
+			policy: OrderingPolicy{Strategy: VersionOrderingSemver, Prereleases: true},
+			in:     versions("v1.10.0", "v2.0.0-rc1"),
+			sorted: []string{"v2.0.0-rc1", "v1.10.0"},
+			latest: "v2.0.0-rc1",
+		},
+		{
+			name:   "semver gitea prerelease",
+			policy: OrderingPolicy{Strategy: VersionOrderingSemver},
+			in:     versions("v1.0.0", "v1.1.0!"),
+			sorted: []string{"v1.1.0", "v1.0.0"},
+			latest: "v1.0.0",
+		},
+		{
+			name:   "latest version name",
+			policy: OrderingPolicy{Strategy: VersionOrderingSemver},
+			in:     versions("v1.0.0", "latest"),
+			sorted: []string{"v1.0.0", "latest"},
+			latest: "latest",
+		},
+		{
+			name:   "calendar",
+			policy: OrderingPolicy{Strategy: VersionOrderingCalendar},
+			in:     versions("2024.05", "2023.12.1", "2024.05-rc1", "2024.10", "nightly"),
+			sorted: []string{"2024.10", "2024.05", "2024.05-rc1", "2023.12.1", "nightly"},
+			latest: "2024.10",
+		},
+		{
+			name:   "calendar dates",
+			policy: OrderingPolicy{Strategy: VersionOrderingCalendar},
+			in:     versions("2024-05-01", "2024-04-30", "24.04.1"),
+			sorted: []string{"2024-05-01", "2024-04-30", "24.04.1"},
+			latest: "2024-05-01",
+		},
+		{
+			name:   "date",
+			policy: OrderingPolicy{Strategy: VersionOrderingDate},
+			in:     versions("v2.0.0", "v1.0.1", "main"),
+			sorted: []string{"main", "v1.0.1", "v2.0.0"},
+			latest: "main",
+		},
+		{
+			name:   "date skips semver prereleases",
+			policy: OrderingPolicy{Strategy: VersionOrderingDate},
+			in:     versions("v1.0.0", "main", "2.0.0-rc1"),
+			sorted: []string{"2.0.0-rc1", "main", "v1.0.0"},
+			latest: "main",
+		},
+		{
+			name:   "date skips gitea prereleases",
+			policy: OrderingPolicy{Strategy: VersionOrderingDate},
+			in:     versions("v1.0.0", "v1.1.0!"),
+			sorted: []string{"v1.1.0", "v1.0.0"},
+			latest: "v1.0.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := slices.Clone(tt.in)
+			latest := tt.policy.Sort(vs)
+			if got := names(vs); !slices.Equal(got, tt.sorted) {
+				t.Errorf("sorted = %v, want %v", got, tt.sorted)
+			}
+			if latest.Version != tt.latest {
+				t.Errorf("latest = %s, want %s", latest.Version, tt.latest)
+			}
+		})
+	}
+}
+
+func TestResolveAlias(t *testing.T) {
+	repoInfo := func(policy OrderingPolicy, vs []Version) RepoInfo {
+		ri := RepoInfo{Versions: vs, Ordering: policy}
+		ri.Latest = policy.Sort(ri.Versions)
+		return ri
+	}
+	semver := repoInfo(OrderingPolicy{Strategy: VersionOrderingSemver},
+		versions("v1.9.0", "v2.3.1", "v2.10.0", "v3.0.0-rc1", "v3.0.0-beta.2", "v3.0.0-alpha1", "v2.11.0-rc.1"))
+	// v2.3.1 is a backport released after v2.10.0
+	date := repoInfo(OrderingPolicy{Strategy: VersionOrderingDate},
+		versions("v1.9.0", "v2.10.0", "v2.3.1"))
+	dateRC := repoInfo(OrderingPolicy{Strategy: VersionOrderingDate},
+		versions("v1.9.0", "2.0.0-rc1"))
+	calendar := repoInfo(OrderingPolicy{Strategy: VersionOrderingCalendar},
+		versions("2023.12", "2024.04", "2024.04.1", "2024.10-rc1"))
+
+	tests := []struct {
+		name    string
+		ri      RepoInfo
+		alias   string
+		aliases map[string]string
+		want    string
+	}{
+		{name: "alias map", ri: semver, alias: "lts", aliases: map[string]string{"lts": "v1.9.0"}, want: "v1.9.0"},
+		{name: "alias map to alias", ri: semver, alias: "current", aliases: map[string]string{"current": "stable"}, want: "v2.10.0"},
+		{name: "latest", ri: semver, alias: LatestVersion, want: "v2.10.0"},
+		{name: "stable", ri: semver, alias: StableAlias, want: "v2.10.0"},
+		{name: "next", ri: semver, alias: NextAlias, want: "v3.0.0-rc1"},
+		{name: "major prefix", ri: semver, alias: "v2", want: "v2.10.0"},
+		{name: "minor prefix", ri: semver, alias: "v2.3", want: "v2.3.1"},
+		{name: "prefix without v", ri: semver, alias: "1", want: "v1.9.0"},
+		{name: "prefix without stable versions", ri: semver, alias: "v3"},
+		{name: "unknown prefix", ri: semver, alias: "v4"},
+		{name: "channel", ri: semver, alias: "rc", want: "v3.0.0-rc1"},
+		{name: "dotted channel", ri: semver, alias: "beta", want: "v3.0.0-beta.2"},
+		{name: "numbered channel", ri: semver, alias: "alpha", want: "v3.0.0-alpha1"},
+		{name: "channel prefix of a word", ri: semver, alias: "r"},
+		{name: "unknown word", ri: semver, alias: "docs"},
+		{name: "date stable skips semver prereleases", ri: dateRC, alias: StableAlias, want: "v1.9.0"},
+		{name: "date prefix takes the highest version", ri: date, alias: "v2", want: "v2.10.0"},
+		{name: "calendar prefix", ri: calendar, alias: "2024", want: "2024.04.1"},
+		{name: "calendar minor prefix", ri: calendar, alias: "2024.04", want: "2024.04.1"},
+		{name: "calendar channel", ri: calendar, alias: "rc", want: "2024.10-rc1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := tt.ri.ResolveAlias(tt.alias, tt.aliases)
+			if ok != (tt.want != "") || v.Version != tt.want {
+				t.Errorf("ResolveAlias(%q) = %q, %v, want %q", tt.alias, v.Version, ok, tt.want)
+			}
+		})
+	}
+}
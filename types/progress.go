@@ -0,0 +1,41 @@
+package types
+
+//go:generate go-enum --marshal --names --values
+
+import "time"
+
+// ENUM(queued,listing,downloading,verifying,unzipping)
+type FetchPhase int
+
+// FetchProgress is the progress of a running fetch of a repository or one of its versions
+type FetchProgress struct {
+	Phase           FetchPhase `json:"phase"`
+	BytesDownloaded int64      `json:"bytes_downloaded"`
+	// BytesTotal is the length of the downloaded archive, -1 if it is unknown
+	BytesTotal    int64     `json:"bytes_total"`
+	FilesUnzipped int       `json:"files_unzipped"`
+	FilesTotal    int       `json:"files_total"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+// ENUM(other,network,not_found,access_denied,gitea,checksum,archive)
+type FetchErrorClass int
+
+// FetchFailure is the last failure of a fetch of a repository or one of its versions,
+// it is removed once the fetch succeeds
+type FetchFailure struct {
+	Class FetchErrorClass `json:"class"`
+	Error string          `json:"error"`
+	// Attempts counts failed runs of the fetch job
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+	// Dead is set once the fetch job ran out of attempts, until then the queue retries it
+	Dead bool `json:"dead,omitempty"`
+	// RetryAt is when a retry of the fetch was requested by a user
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// Retrying reports whether a retry was requested after the failure
+func (f FetchFailure) Retrying() bool {
+	return f.RetryAt.After(f.FailedAt)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"regexp"
 	"strings"
 	"time"
 
@@ -63,14 +64,47 @@ type (
 	PageFile struct {
 		Name string     `json:"name"`
 		SHA  PageSHA256 `json:"sha"`
+		// Encodings maps a content coding like gzip or br to the compressed variant of the file
+		Encodings map[string]PageSHA256 `json:"encodings,omitempty"`
 	}
 )
 
+// PagesConfig is the configuration a site ships along with its pages
+type PagesConfig struct {
+	Redirects []RedirectRule `json:"redirects,omitempty"`
+	Headers   []HeaderRule   `json:"headers,omitempty"`
+	// CNAME is the custom domain from the CNAME file of the site
+	CNAME string `json:"cname,omitempty"`
+	// Aliases map version aliases to versions, only the ones of the latest version are used
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// HeaderRule is a rule of a Netlify-style _headers file, Headers are added
+// to responses for paths matching Path
+type HeaderRule struct {
+	Path    string              `json:"path"`
+	Headers map[string][]string `json:"headers"`
+}
+
+// RedirectRule is a rule of a Netlify-style _redirects file.
+// Statuses 3xx redirect to To, other statuses serve To with the status.
+type RedirectRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+	// Force applies the rule even if a file exists at From
+	Force bool `json:"force,omitempty"`
+}
+
 type Version struct {
-	Version   string         `json:"name"`
-	CreatedAt time.Time      `json:"created_at"`
-	SHA       PagesSHA256    `json:"sha"`
-	Extra     map[string]any `json:"extra,omitempty"`
+	Version string `json:"name"`
+	// Title is a human readable name of the version, e.g. the name of the release
+	Title string `json:"title,omitempty"`
+	// Prerelease is set for versions marked as prereleases in Gitea
+	Prerelease bool           `json:"prerelease,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	SHA        PagesSHA256    `json:"sha"`
+	Extra      map[string]any `json:"extra,omitempty"`
 }
 
 type Repo struct {
@@ -78,6 +112,18 @@ type Repo struct {
 	Repo  string `json:"repo"`
 }
 
+// ENUM(cname,admin)
+type DomainSource int
+
+// CustomDomain maps a custom domain to the repository served at its root
+type CustomDomain struct {
+	Repo   Repo         `json:"repo"`
+	Source DomainSource `json:"source"`
+	// Pending domains are claimed by a CNAME file without verification, they are not served
+	// until the TXT record of the domain names the repository or an administrator maps the domain
+	Pending bool `json:"pending,omitempty"`
+}
+
 // ENUM(branch,release,package)
 type RepoType int
 
@@ -98,6 +144,125 @@ type RepoInfo struct {
 	Repo     Repo      `json:"repo"`
 	Latest   Version   `json:"latest"`
 	Versions []Version `json:"versions"`
+	// Pinned is set when Latest is pinned by a repository administrator
+	Pinned bool `json:"pinned,omitempty"`
+	// Ordering sorted Versions, aliases are resolved with it
+	Ordering OrderingPolicy `json:"ordering"`
+}
+
+// RepoPin overrides the latest version of a repository until it is removed
+type RepoPin struct {
+	Version  string    `json:"version"`
+	PinnedBy string    `json:"pinned_by,omitempty"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// ServedVersion is the version last served as the latest or a concrete version of a repository,
+// it is served while a newer version is fetched
+type ServedVersion struct {
+	Version Version `json:"version"`
+	// StaleSince is when the version was superseded, the record expires MaxStaleness later
+	StaleSince time.Time `json:"stale_since,omitempty"`
+}
+
+// RepoHook is a webhook registered in the repository by pages-server
+type RepoHook struct {
+	HookID int64    `json:"hook_id"`
+	Type   RepoType `json:"type"`
+	Events []string `json:"events"`
+	// SecretSHA256 is a hash of the secret the hook was registered with
+	SecretSHA256 string `json:"secret_sha256"`
+	// Adopted hooks were created by users, they are kept when the topic is removed
+	Adopted bool `json:"adopted,omitempty"`
+}
+
+// Duration is a time.Duration marshalled as a string like "720h"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RetentionPolicy selects versions of a repository which are kept.
+// A version is removed only if it is outside of every enabled limit
+// and does not match KeepPattern. Without limits every version is kept.
+type RetentionPolicy struct {
+	// KeepLast keeps the newest versions, 0 disables the limit
+	KeepLast int `json:"keep_last,omitempty"`
+	// KeepWithin keeps versions created within the duration, 0 disables the limit
+	KeepWithin Duration `json:"keep_within,omitempty"`
+	// KeepPattern is a regular expression, matching versions are always kept
+	KeepPattern string `json:"keep_pattern,omitempty"`
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 {
+		return fmt.Errorf("keep_last must not be negative, got %d", p.KeepLast)
+	}
+	if p.KeepWithin < 0 {
+		return fmt.Errorf("keep_within must not be negative, got %s", time.Duration(p.KeepWithin))
+	}
+	if _, err := regexp.Compile(p.KeepPattern); err != nil {
+		return fmt.Errorf("invalid keep_pattern: %w", err)
+	}
+	return nil
+}
+
+// Apply returns versions kept by the policy. versions must be sorted highest first,
+// the latest version is always kept.
+func (p RetentionPolicy) Apply(versions []Version, latest Version, now time.Time) ([]Version, error) {
+	if p.KeepLast == 0 && p.KeepWithin == 0 {
+		return versions, nil
+	}
+	var pattern *regexp.Regexp
+	if p.KeepPattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(p.KeepPattern); err != nil {
+			return nil, fmt.Errorf("invalid keep_pattern: %w", err)
+		}
+	}
+	ret := make([]Version, 0, len(versions))
+	for i, v := range versions {
+		keep := v.Version == latest.Version ||
+			(p.KeepLast > 0 && i < p.KeepLast) ||
+			(p.KeepWithin > 0 && now.Sub(v.CreatedAt) <= time.Duration(p.KeepWithin)) ||
+			(pattern != nil && pattern.MatchString(v.Version))
+		if keep {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+// RepoSettings are per-repository overrides of server-wide settings
+type RepoSettings struct {
+	// Retention replaces the server-wide retention policy
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// Ordering replaces the server-wide ordering of versions
+	Ordering *OrderingPolicy `json:"ordering,omitempty"`
+}
+
+func (s RepoSettings) Validate() error {
+	if s.Retention != nil {
+		if err := s.Retention.Validate(); err != nil {
+			return fmt.Errorf("invalid retention: %w", err)
+		}
+	}
+	if s.Ordering != nil {
+		if err := s.Ordering.Validate(); err != nil {
+			return fmt.Errorf("invalid ordering: %w", err)
+		}
+	}
+	return nil
 }
 
 type RepoFileAtVersion struct {
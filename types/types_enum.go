@@ -11,6 +11,86 @@ import (
 	"strings"
 )
 
+const (
+	// DomainSourceCname is a DomainSource of type Cname.
+	DomainSourceCname DomainSource = iota
+	// DomainSourceAdmin is a DomainSource of type Admin.
+	DomainSourceAdmin
+)
+
+var ErrInvalidDomainSource = fmt.Errorf("not a valid DomainSource, try [%s]", strings.Join(_DomainSourceNames, ", "))
+
+const _DomainSourceName = "cnameadmin"
+
+var _DomainSourceNames = []string{
+	_DomainSourceName[0:5],
+	_DomainSourceName[5:10],
+}
+
+// DomainSourceNames returns a list of possible string values of DomainSource.
+func DomainSourceNames() []string {
+	tmp := make([]string, len(_DomainSourceNames))
+	copy(tmp, _DomainSourceNames)
+	return tmp
+}
+
+// DomainSourceValues returns a list of the values for DomainSource
+func DomainSourceValues() []DomainSource {
+	return []DomainSource{
+		DomainSourceCname,
+		DomainSourceAdmin,
+	}
+}
+
+var _DomainSourceMap = map[DomainSource]string{
+	DomainSourceCname: _DomainSourceName[0:5],
+	DomainSourceAdmin: _DomainSourceName[5:10],
+}
+
+// String implements the Stringer interface.
+func (x DomainSource) String() string {
+	if str, ok := _DomainSourceMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("DomainSource(%d)", x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x DomainSource) IsValid() bool {
+	_, ok := _DomainSourceMap[x]
+	return ok
+}
+
+var _DomainSourceValue = map[string]DomainSource{
+	_DomainSourceName[0:5]:  DomainSourceCname,
+	_DomainSourceName[5:10]: DomainSourceAdmin,
+}
+
+// ParseDomainSource attempts to convert a string to a DomainSource.
+func ParseDomainSource(name string) (DomainSource, error) {
+	if x, ok := _DomainSourceValue[name]; ok {
+		return x, nil
+	}
+	return DomainSource(0), fmt.Errorf("%s is %w", name, ErrInvalidDomainSource)
+}
+
+// MarshalText implements the text marshaller method.
+func (x DomainSource) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText implements the text unmarshaller method.
+func (x *DomainSource) UnmarshalText(text []byte) error {
+	name := string(text)
+	tmp, err := ParseDomainSource(name)
+	if err != nil {
+		return err
+	}
+	*x = tmp
+	return nil
+}
+
 const (
 	// RepoTypeBranch is a RepoType of type Branch.
 	RepoTypeBranch RepoType = iota
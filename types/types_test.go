@@ -0,0 +1,66 @@
+package types
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyApply(t *testing.T) {
+	// v5 is the newest, created on 2024-01-05
+	vs := versions("v1", "v2", "v3", "v4", "v5")
+	slices.Reverse(vs)
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := Duration(24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		latest string
+		kept   []string
+		err    bool
+	}{
+		{name: "no limits", policy: RetentionPolicy{}, latest: "v5", kept: []string{"v5", "v4", "v3", "v2", "v1"}},
+		{name: "pattern without limits", policy: RetentionPolicy{KeepPattern: "^v1$"}, latest: "v5", kept: []string{"v5", "v4", "v3", "v2", "v1"}},
+		{name: "keep last", policy: RetentionPolicy{KeepLast: 2}, latest: "v5", kept: []string{"v5", "v4"}},
+		{name: "keep last more than versions", policy: RetentionPolicy{KeepLast: 10}, latest: "v5", kept: []string{"v5", "v4", "v3", "v2", "v1"}},
+		{name: "keep within", policy: RetentionPolicy{KeepWithin: 6 * day}, latest: "v5", kept: []string{"v5", "v4"}},
+		{name: "keep within nothing", policy: RetentionPolicy{KeepWithin: day}, latest: "v5", kept: []string{"v5"}},
+		{name: "either limit keeps", policy: RetentionPolicy{KeepLast: 1, KeepWithin: 7 * day}, latest: "v5", kept: []string{"v5", "v4", "v3"}},
+		{name: "pattern", policy: RetentionPolicy{KeepLast: 1, KeepPattern: "^v[12]$"}, latest: "v5", kept: []string{"v5", "v2", "v1"}},
+		{name: "latest outside of limits", policy: RetentionPolicy{KeepLast: 1}, latest: "v3", kept: []string{"v5", "v3"}},
+		{name: "invalid pattern", policy: RetentionPolicy{KeepLast: 1, KeepPattern: "("}, latest: "v5", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, err := tt.policy.Apply(slices.Clone(vs), Version{Version: tt.latest}, now)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if got := names(kept); !tt.err && !slices.Equal(got, tt.kept) {
+				t.Errorf("kept = %v, want %v", got, tt.kept)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		err    bool
+	}{
+		{name: "empty", policy: RetentionPolicy{}},
+		{name: "all limits", policy: RetentionPolicy{KeepLast: 3, KeepWithin: Duration(time.Hour), KeepPattern: "^v1\\."}},
+		{name: "negative keep last", policy: RetentionPolicy{KeepLast: -1}, err: true},
+		{name: "negative keep within", policy: RetentionPolicy{KeepWithin: Duration(-time.Hour)}, err: true},
+		{name: "invalid pattern", policy: RetentionPolicy{KeepPattern: "[v"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.err {
+				t.Errorf("err = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
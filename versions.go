@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+type RetentionInfo struct {
+	KeepLast    int           `cli:"usage:'keep only the highest versions of every repository in the order of --ordering-strategy, 0 disables the limit'"`
+	KeepWithin  time.Duration `cli:"usage:'keep only versions created within the duration, 0 disables the limit'"`
+	KeepPattern string        `cli:"usage:'regular expression, versions with matching names are always kept'"`
+}
+
+func (ri RetentionInfo) Policy() types.RetentionPolicy {
+	return types.RetentionPolicy{
+		KeepLast:    ri.KeepLast,
+		KeepWithin:  types.Duration(ri.KeepWithin),
+		KeepPattern: ri.KeepPattern,
+	}
+}
+
+type OrderingInfo struct {
+	Strategy    string `cli:"usage:'how versions are ordered and the latest version is selected: semver, calendar or date',default:'semver'"`
+	Prereleases bool   `cli:"usage:'let prerelease versions become the latest version'"`
+}
+
+func (oi OrderingInfo) Policy() (types.OrderingPolicy, error) {
+	strategy, err := types.ParseVersionOrdering(oi.Strategy)
+	if err != nil {
+		return types.OrderingPolicy{}, err
+	}
+	return types.OrderingPolicy{Strategy: strategy, Prereleases: oi.Prereleases}, nil
+}
+
+// repoVersions stores versions fetched from Gitea applying server-wide and repository settings
+type repoVersions struct {
+	db        *database.Database
+	retention types.RetentionPolicy
+	ordering  types.OrderingPolicy
+}
+
+func newRepoVersions(db *database.Database, retention types.RetentionPolicy, ordering types.OrderingPolicy) *repoVersions {
+	return &repoVersions{db: db, retention: retention, ordering: ordering}
+}
+
+// policies returns retention and ordering policies of the repository
+func (rv *repoVersions) policies(repo types.Repo) (types.RetentionPolicy, types.OrderingPolicy, error) {
+	settings, _, err := rv.db.RepoSettings().Get(repo)
+	if err != nil {
+		return rv.retention, rv.ordering, fmt.Errorf("failed to get repo settings: %w", err)
+	}
+	retention, ordering := rv.retention, rv.ordering
+	if settings.Retention != nil {
+		retention = *settings.Retention
+	}
+	if settings.Ordering != nil {
+		ordering = *settings.Ordering
+	}
+	return retention, ordering, nil
+}
+
+// latest sorts versions and selects the latest one, the version pinned by repository administrators
+// takes precedence while it exists
+func (rv *repoVersions) latest(repo types.Repo, ordering types.OrderingPolicy, versions []types.Version) (types.Version, bool, error) {
+	latest := ordering.Sort(versions)
+	pin, ok, err := rv.db.RepoPins().Get(repo)
+	if err != nil {
+		return latest, false, fmt.Errorf("failed to get repo pin: %w", err)
+	}
+	if !ok {
+		return latest, false, nil
+	}
+	for _, v := range versions {
+		if v.Version == pin.Version {
+			return v, true, nil
+		}
+	}
+	slog.Warn("pinned version not found, using the latest version", "repo", repo, "pinned", pin.Version, "latest", latest.Version)
+	return latest, false, nil
+}
+
+// RefreshLatest selects the latest version among known versions of the repository after a change of the pin
+func (rv *repoVersions) RefreshLatest(repo types.Repo) (types.RepoInfo, error) {
+	repoInfo, ok, err := rv.db.RepoPages().Get(repo)
+	if err != nil || !ok {
+		return repoInfo, err
+	}
+	_, ordering, err := rv.policies(repo)
+	if err != nil {
+		return repoInfo, err
+	}
+	repoInfo.Ordering = ordering
+	repoInfo.Latest, repoInfo.Pinned, err = rv.latest(repo, ordering, repoInfo.Versions)
+	if err != nil {
+		return repoInfo, err
+	}
+	if err := rv.db.RepoPages().Set(repo, repoInfo); err != nil {
+		return repoInfo, err
+	}
+	if err := refreshServedVersions(rv.db, repo); err != nil {
+		return repoInfo, err
+	}
+	return repoInfo, syncCNAME(rv.db, repo)
+}
+
+// Update sorts versions, selects the latest one, drops the ones which fall out of the retention policy,
+// saves the repo info and enqueues fetching of every kept version
+func (rv *repoVersions) Update(ctx context.Context, repo types.Repo, versions []types.Version, fetch func(types.Version) database.TaskElement) error {
+	if len(versions) == 0 {
+		slog.Error("no versions found for repo", "owner", repo.String())
+		return nil
+	}
+	retention, ordering, err := rv.policies(repo)
+	if err != nil {
+		return err
+	}
+	latest, pinned, err := rv.latest(repo, ordering, versions)
+	if err != nil {
+		return err
+	}
+	kept, err := retention.Apply(versions, latest, time.Now())
+	if err != nil {
+		return err
+	}
+	if removed := len(versions) - len(kept); removed != 0 {
+		slog.Info("versions removed by retention policy", "repo", repo, "removed", removed, "kept", len(kept))
+	}
+	repoInfo := types.RepoInfo{
+		Repo:     repo,
+		Latest:   latest,
+		Versions: kept,
+		Pinned:   pinned,
+		Ordering: ordering,
+	}
+	err = rv.db.RepoPages().Set(repo, repoInfo)
+	if err != nil {
+		return err
+	}
+	err = refreshServedVersions(rv.db, repo)
+	if err != nil {
+		return err
+	}
+	// the latest version may have changed along with its CNAME
+	err = syncCNAME(rv.db, repo)
+	if err != nil {
+		return err
+	}
+	q := database.QueueFromContext(ctx)
+	for _, v := range repoInfo.Versions {
+		err := q.Enqueue(ctx, fetch(v))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionsFile lists versions of the site in the format of mike's versions.json
+const versionsFile = "_versions.json"
+
+// versionEntry is a version in versions.json of mike, created_at and pinned are extensions
+type versionEntry struct {
+	Version   string    `json:"version"`
+	Title     string    `json:"title"`
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"created_at"`
+	Pinned    bool      `json:"pinned,omitempty"`
+}
+
+// versionEntries converts repo info to versions.json entries in the order of RepoInfo.Versions,
+// aliases from the alias map are listed along with latest
+func versionEntries(repoInfo types.RepoInfo, aliases map[string]string) []versionEntry {
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	slices.Sort(names)
+	aliasesOf := map[string][]string{}
+	for _, alias := range names {
+		if v, ok := repoInfo.ResolveAlias(alias, aliases); ok {
+			aliasesOf[v.Version] = append(aliasesOf[v.Version], alias)
+		}
+	}
+	entries := make([]versionEntry, 0, len(repoInfo.Versions))
+	for _, v := range repoInfo.Versions {
+		entry := versionEntry{
+			Version:   v.Version,
+			Title:     v.Title,
+			Aliases:   []string{},
+			CreatedAt: v.CreatedAt,
+		}
+		if entry.Title == "" {
+			entry.Title = v.Version
+		}
+		if v.Version == repoInfo.Latest.Version && v.Version != types.LatestVersion {
+			entry.Aliases = append(entry.Aliases, types.LatestVersion)
+		}
+		entry.Aliases = append(entry.Aliases, aliasesOf[v.Version]...)
+		entry.Pinned = repoInfo.Pinned && v.Version == repoInfo.Latest.Version
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// serveVersions answers with versions.json of the repository, the access is checked by the caller
+func serveVersions(w http.ResponseWriter, gi GiteaPagesInfo, db *database.Database, q *database.Queue, repo types.Repo, rt types.RepoType) {
+	repoInfo, ok, err := db.RepoPages().Get(repo)
+	if err != nil {
+		slog.Error("failed to get repo info", "repo", repo, "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	if !ok {
+		if err := fetchRepo(repo, rt, q); err != nil {
+			slog.Error("failed to enqueue fetch repo", "err", err)
+		}
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "versions are being fetched", http.StatusServiceUnavailable)
+		return
+	}
+	config, _, err := db.PagesConfig().Get(repoInfo.Latest.SHA)
+	if err != nil {
+		slog.Error("failed to get pages config", "repo", repo, "err", err)
+		errorPage(gi, http.StatusInternalServerError, err, w)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, versionEntries(repoInfo, config.Aliases))
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	clive "github.com/ASMfreaK/clive2"
+	"github.com/ASMfreaK/pages-server/pages-server/consts"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+	"github.com/urfave/cli/v2"
+)
+
+type WebhooksInfo struct {
+	ReconcileInterval time.Duration `cli:"usage:'how often to register webhooks for repositories with pages- topics, 0 disables registration',default:'1h'"`
+}
+
+// hookEvents returns events the webhook should be subscribed to for the pages mode
+func hookEvents(rt types.RepoType) ([]string, error) {
+	switch rt {
+	case types.RepoTypeBranch:
+		return []string{"create", "delete", "push"}, nil
+	case types.RepoTypeRelease:
+		return []string{"release"}, nil
+	case types.RepoTypePackage:
+		return []string{"package"}, nil
+	default:
+		return nil, fmt.Errorf("unexpected repo type %q", rt)
+	}
+}
+
+// hookBranchFilter returns branch filter of the webhook for the pages mode
+func hookBranchFilter(rt types.RepoType) string {
+	if rt == types.RepoTypeBranch {
+		return fmt.Sprintf("{%s,%s*}", consts.PagesBranch, consts.PagesBranchPrefix)
+	}
+	return "*"
+}
+
+const (
+	webhookCreate = "create"
+	webhookUpdate = "update"
+	webhookDelete = "delete"
+	webhookKeep   = "keep"
+	// webhookForget stops managing a hook adopted from users without deleting it
+	webhookForget = "forget"
+)
+
+type webhookAction struct {
+	Repo   types.Repo     `json:"repo"`
+	Type   types.RepoType `json:"type"`
+	Action string         `json:"action"`
+	HookID int64          `json:"hook_id,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// webhookReconciler keeps repository webhooks in sync with pages- topics:
+// repositories with a topic get a hook pointing at the pages server,
+// hooks created by the reconciler are removed once the topic is gone.
+// Hooks pointing at the pages server which users created are adopted and only forgotten.
+type webhookReconciler struct {
+	c     *gitea.Client
+	db    *database.Database
+	gitea GiteaInfo
+
+	mu sync.Mutex
+}
+
+func newWebhookReconciler(c *gitea.Client, db *database.Database, g GiteaInfo) *webhookReconciler {
+	return &webhookReconciler{c: c, db: db, gitea: g}
+}
+
+func (wr *webhookReconciler) secret() string {
+	for _, secret := range wr.gitea.HookSecret {
+		if secret != "" {
+			return secret
+		}
+	}
+	return ""
+}
+
+// Run reconciles webhooks every interval until ctx is done
+func (wr *webhookReconciler) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		slog.Info("webhook registration is disabled")
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := wr.Reconcile(ctx, false); err != nil {
+			slog.Error("failed to reconcile webhooks", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// pagesRepos finds all repositories with pages- topics, repositories with several topics are returned separately
+func (wr *webhookReconciler) pagesRepos(ctx context.Context) (map[types.Repo]types.RepoType, map[types.Repo]struct{}, error) {
+	ret := make(map[types.Repo]types.RepoType)
+	ambiguous := make(map[types.Repo]struct{})
+	for _, rt := range types.RepoTypeValues() {
+		topic := consts.PagesLabelPrefix + rt.String()
+		repos, err := allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+			return wr.c.SearchRepos(gitea.SearchRepoOptions{
+				ListOptions:    opts,
+				Keyword:        topic,
+				KeywordIsTopic: true,
+			})
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to search repos with %s topic: %w", topic, err)
+		}
+		for _, repo := range repos {
+			if repo.Owner == nil {
+				continue
+			}
+			r := types.Repo{Owner: repo.Owner.UserName, Repo: repo.Name}
+			if _, ok := ret[r]; ok {
+				ambiguous[r] = struct{}{}
+			}
+			ret[r] = rt
+		}
+	}
+	for r := range ambiguous {
+		slog.Warn("repository has several pages- topics, skipping its webhook", "repo", r)
+		delete(ret, r)
+	}
+	return ret, ambiguous, nil
+}
+
+// Reconcile creates, updates and removes webhooks. With dryRun it only reports what would be done.
+func (wr *webhookReconciler) Reconcile(ctx context.Context, dryRun bool) ([]webhookAction, error) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	secret := wr.secret()
+	if secret == "" {
+		return nil, errors.New("no gitea hook secret is configured")
+	}
+	secretSum := sha256.Sum256([]byte(secret))
+	secretSHA := hex.EncodeToString(secretSum[:])
+
+	desired, ambiguous, err := wr.pagesRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[types.Repo]types.RepoHook)
+	err = wr.db.RepoHooks().ForEach(func(k string, v types.RepoHook) error {
+		var r types.Repo
+		if err := r.Parse(k); err != nil {
+			return err
+		}
+		managed[r] = v
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list registered webhooks: %w", err)
+	}
+
+	var actions []webhookAction
+	for repo, rt := range desired {
+		action, aerr := wr.ensureHook(ctx, repo, rt, secret, secretSHA, dryRun)
+		if aerr != nil {
+			slog.Error("failed to register webhook", "repo", repo, "err", aerr)
+			action.Error = aerr.Error()
+		}
+		actions = append(actions, action)
+	}
+	for repo, hook := range managed {
+		if _, ok := desired[repo]; ok {
+			continue
+		}
+		// the topics have to be fixed first, keep whatever hook the repository has
+		if _, ok := ambiguous[repo]; ok {
+			continue
+		}
+		action := webhookAction{Repo: repo, Type: hook.Type, Action: webhookDelete, HookID: hook.HookID}
+		if hook.Adopted {
+			action.Action = webhookForget
+		}
+		if !dryRun {
+			if derr := wr.deleteHook(repo, hook); derr != nil {
+				slog.Error("failed to remove webhook", "repo", repo, "hook", hook.HookID, "err", derr)
+				action.Error = derr.Error()
+			}
+		}
+		actions = append(actions, action)
+	}
+	slices.SortFunc(actions, func(a, b webhookAction) int {
+		return strings.Compare(a.Repo.String(), b.Repo.String())
+	})
+	for _, action := range actions {
+		if action.Action == webhookKeep {
+			continue
+		}
+		slog.Info("webhook reconciled", "repo", action.Repo, "type", action.Type, "action", action.Action, "hook", action.HookID, "dryRun", dryRun, "err", action.Error)
+	}
+	return actions, nil
+}
+
+func (wr *webhookReconciler) ensureHook(ctx context.Context, repo types.Repo, rt types.RepoType, secret, secretSHA string, dryRun bool) (webhookAction, error) {
+	action := webhookAction{Repo: repo, Type: rt}
+	events, err := hookEvents(rt)
+	if err != nil {
+		return action, err
+	}
+	hooks, err := allGiteaPages(ctx, func(_ context.Context, opts gitea.ListOptions) ([]*gitea.Hook, *gitea.Response, error) {
+		return wr.c.ListRepoHooks(repo.Owner, repo.Repo, gitea.ListHooksOptions{ListOptions: opts})
+	})
+	if err != nil {
+		action.Action = webhookCreate
+		return action, fmt.Errorf("failed to list hooks: %w", err)
+	}
+	var existing *gitea.Hook
+	for _, hook := range hooks {
+		if hook.Config["url"] == wr.gitea.HookURL() {
+			existing = hook
+			break
+		}
+	}
+	stored, _, err := wr.db.RepoHooks().Get(repo)
+	if err != nil {
+		return action, fmt.Errorf("failed to get registered webhook: %w", err)
+	}
+	var adopted bool
+	config := map[string]string{
+		"url":          wr.gitea.HookURL(),
+		"content_type": "json",
+		"secret":       secret,
+	}
+
+	if existing == nil {
+		action.Action = webhookCreate
+		if dryRun {
+			return action, nil
+		}
+		hook, _, cerr := wr.c.CreateRepoHook(repo.Owner, repo.Repo, gitea.CreateHookOption{
+			Type:         gitea.HookTypeGitea,
+			Config:       config,
+			Events:       events,
+			BranchFilter: hookBranchFilter(rt),
+			Active:       true,
+		})
+		if cerr != nil {
+			return action, fmt.Errorf("failed to create hook: %w", cerr)
+		}
+		action.HookID = hook.ID
+	} else {
+		// a hook the reconciler did not create belongs to the user
+		adopted = stored.Adopted || stored.HookID != existing.ID
+		action.HookID = existing.ID
+		action.Action = webhookKeep
+		existingEvents := slices.Clone(existing.Events)
+		slices.Sort(existingEvents)
+		if !existing.Active || !slices.Equal(existingEvents, events) ||
+			stored.HookID != existing.ID || stored.SecretSHA256 != secretSHA {
+			action.Action = webhookUpdate
+		}
+		if dryRun || action.Action == webhookKeep {
+			return action, nil
+		}
+		active := true
+		_, err = wr.c.EditRepoHook(repo.Owner, repo.Repo, existing.ID, gitea.EditHookOption{
+			Config:       config,
+			Events:       events,
+			BranchFilter: hookBranchFilter(rt),
+			Active:       &active,
+		})
+		if err != nil {
+			return action, fmt.Errorf("failed to update hook: %w", err)
+		}
+	}
+	err = wr.db.RepoHooks().Set(repo, types.RepoHook{
+		HookID:       action.HookID,
+		Type:         rt,
+		Events:       events,
+		SecretSHA256: secretSHA,
+		Adopted:      adopted,
+	})
+	if err != nil {
+		return action, fmt.Errorf("failed to save registered webhook: %w", err)
+	}
+	return action, nil
+}
+
+// deleteHook removes the hook created by the reconciler, adopted hooks are only forgotten
+func (wr *webhookReconciler) deleteHook(repo types.Repo, hook types.RepoHook) error {
+	if hook.Adopted {
+		return wr.db.RepoHooks().Delete(repo)
+	}
+	rsp, err := wr.c.DeleteRepoHook(repo.Owner, repo.Repo, hook.HookID)
+	if err != nil && (rsp == nil || rsp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("failed to delete hook: %w", err)
+	}
+	return wr.db.RepoHooks().Delete(repo)
+}
+
+type ReconcileWebhooks struct {
+	*clive.Command `cli:"name:'reconcile-webhooks',usage:'register gitea webhooks for repositories with pages- topics and exit'"`
+	DryRun         bool `cli:"usage:'only print what would be changed'"`
+}
+
+func (cmd *ReconcileWebhooks) Action(ctx *cli.Context) error {
+	a := cmd.Root(ctx).(*app)
+	setupLogging()
+	db, err := database.New(a.Database)
+	if err != nil {
+		return fmt.Errorf("failed to create database %w", err)
+	}
+	defer db.Close()
+	c, err := gitea.NewClient(a.Gitea.URL, gitea.SetToken(a.Gitea.AdminToken))
+	if err != nil {
+		return fmt.Errorf("failed to create gitea client %w", err)
+	}
+	actions, err := newWebhookReconciler(c, db, a.Gitea).Reconcile(ctx.Context, cmd.DryRun)
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		fmt.Fprintf(ctx.App.Writer, "%-8s %-8s %s", action.Action, action.Type, action.Repo)
+		if action.Error != "" {
+			fmt.Fprintf(ctx.App.Writer, " (error: %s)", action.Error)
+		}
+		fmt.Fprintln(ctx.App.Writer)
+	}
+	return nil
+}
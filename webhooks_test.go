@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/ASMfreaK/pages-server/pages-server/database"
+	"github.com/ASMfreaK/pages-server/pages-server/types"
+)
+
+// fakeHooksGitea serves repository search by topic and repository hooks
+type fakeHooksGitea struct {
+	mu      sync.Mutex
+	topics  map[string][]string
+	hooks   map[string][]*gitea.Hook
+	nextID  int64
+	deleted []int64
+}
+
+func (f *fakeHooksGitea) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/repos/search", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var repos []*gitea.Repository
+		for repo, topics := range f.topics {
+			if slices.Contains(topics, r.URL.Query().Get("q")) {
+				var rp types.Repo
+				_ = rp.Parse(repo)
+				repos = append(repos, &gitea.Repository{Name: rp.Repo, Owner: &gitea.User{UserName: rp.Owner}})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": repos})
+	})
+	mux.HandleFunc("GET /api/v1/repos/{owner}/{repo}/hooks", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(f.hooks[r.PathValue("owner")+"/"+r.PathValue("repo")])
+	})
+	mux.HandleFunc("POST /api/v1/repos/{owner}/{repo}/hooks", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var opt gitea.CreateHookOption
+		_ = json.NewDecoder(r.Body).Decode(&opt)
+		f.nextID++
+		hook := &gitea.Hook{ID: f.nextID, Config: opt.Config, Events: opt.Events, Active: opt.Active}
+		repo := r.PathValue("owner") + "/" + r.PathValue("repo")
+		f.hooks[repo] = append(f.hooks[repo], hook)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(hook)
+	})
+	mux.HandleFunc("PATCH /api/v1/repos/{owner}/{repo}/hooks/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("DELETE /api/v1/repos/{owner}/{repo}/hooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		f.deleted = append(f.deleted, id)
+		repo := r.PathValue("owner") + "/" + r.PathValue("repo")
+		f.hooks[repo] = slices.DeleteFunc(f.hooks[repo], func(h *gitea.Hook) bool { return h.ID == id })
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func TestReconcileKeepsAdoptedHooks(t *testing.T) {
+	giteaInfo := GiteaInfo{HookSecret: []string{"secret"}, PagesAddrFromGitea: "http://pages:8000"}
+	fake := &fakeHooksGitea{
+		topics: map[string][]string{
+			"owner/created": {"pages-release"},
+			"owner/adopted": {"pages-release"},
+		},
+		hooks: map[string][]*gitea.Hook{
+			// created by the user before the reconciler ran
+			"owner/adopted": {{ID: 100, Config: map[string]string{"url": giteaInfo.HookURL()}, Events: []string{"release"}, Active: true}},
+		},
+	}
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+	c, err := gitea.NewClient(srv.URL, gitea.SetGiteaVersion(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.New(database.Params{Filename: filepath.Join(t.TempDir(), "pages-server.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wr := newWebhookReconciler(c, db, giteaInfo)
+
+	actionsOf := func() map[string]string {
+		t.Helper()
+		actions, err := wr.Reconcile(context.Background(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make(map[string]string)
+		for _, a := range actions {
+			if a.Error != "" {
+				t.Errorf("%s: %s", a.Repo, a.Error)
+			}
+			ret[a.Repo.String()] = a.Action
+		}
+		return ret
+	}
+
+	got := actionsOf()
+	if want := map[string]string{"owner/created": webhookCreate, "owner/adopted": webhookUpdate}; !maps.Equal(got, want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+	hook, _, err := db.RepoHooks().Get(types.Repo{Owner: "owner", Repo: "adopted"})
+	if err != nil || !hook.Adopted || hook.HookID != 100 {
+		t.Fatalf("adopted hook = %+v, %v", hook, err)
+	}
+	if got := actionsOf(); !maps.Equal(got, map[string]string{"owner/created": webhookKeep, "owner/adopted": webhookKeep}) {
+		t.Fatalf("actions of the second run = %v", got)
+	}
+
+	fake.mu.Lock()
+	fake.topics = nil
+	fake.mu.Unlock()
+	got = actionsOf()
+	if want := map[string]string{"owner/created": webhookDelete, "owner/adopted": webhookForget}; !maps.Equal(got, want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+	if want := []int64{1}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted hooks %v, want %v", fake.deleted, want)
+	}
+	if _, ok, err := db.RepoHooks().Get(types.Repo{Owner: "owner", Repo: "adopted"}); err != nil || ok {
+		t.Errorf("adopted hook is still managed: %v, %v", ok, err)
+	}
+}